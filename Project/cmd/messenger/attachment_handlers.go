@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// uploadAttachmentHandler accepts a file in the "file" multipart field. The attachment isn't
+// visible to anybody else until it is sent with a message.
+func (app *application) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Attachments are much larger than JSON bodies, give slow clients more time to send them.
+	// The write timeout runs while the body is read too, so it is pushed back past the read one.
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(6 * time.Minute)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	upload, err := app.readUpload(w, r, "file", app.config.attachments.maxBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attachment, err := app.storeAttachment(r.Context(), user.ID, "", upload.filename, upload.contentType, upload.data)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrQuotaExceeded):
+			v := validator.New()
+			v.AddError("file", "storage quota exceeded")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"attachment": attachment}, nil)
+}
+
+// storeAttachment puts an uploaded file, and a thumbnail for images, into the blob store and
+// records it as an attachment of the user. The stored files are removed again if the attachment
+// can't be recorded, e.g. because it doesn't fit in the user's quota. uploadID names the resumable
+// upload the file was sent with, its reserved space is then not counted against the quota again.
+func (app *application) storeAttachment(ctx context.Context, userID int64, uploadID, filename, contentType string, data []byte) (*models.Attachment, error) {
+	key, err := newMediaKey("attachments", "")
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &models.Attachment{
+		OwnerId:     userID,
+		StorageKey:  key,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	if err := app.blobs.Put(ctx, key, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return nil, err
+	}
+	keys := []string{key}
+
+	if info, ok := makeThumbnail(data, contentType); ok {
+		thumbnailKey := key + "_thumb" + imageContentTypes[info.thumbnailType]
+		err := app.blobs.Put(ctx, thumbnailKey, bytes.NewReader(info.thumbnail), int64(len(info.thumbnail)), info.thumbnailType)
+		if err != nil {
+			app.removeBlobs(keys)
+			return nil, err
+		}
+		keys = append(keys, thumbnailKey)
+
+		attachment.Width, attachment.Height = &info.width, &info.height
+		attachment.ThumbnailKey = &thumbnailKey
+	}
+
+	if err := app.models.Attachments.Insert(attachment, app.config.attachments.quota, uploadID); err != nil {
+		app.removeBlobs(keys)
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// removeBlobs deletes blobs that turned out not to be needed. Failures are only logged, an
+// orphaned blob does no harm beyond taking up space.
+func (app *application) removeBlobs(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := app.blobs.Delete(ctx, key); err != nil {
+			app.logger.PrintError(err, map[string]string{"key": key})
+		}
+	}
+}
+
+// cleanFilename reduces a client supplied file name to something safe to store and to send back
+// in a Content-Disposition header.
+func cleanFilename(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+
+	for len(filename) > 255 {
+		_, size := utf8.DecodeLastRuneInString(filename)
+		filename = filename[:len(filename)-size]
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		return "file"
+	}
+	return filename
+}
+
+// downloadAttachmentHandler serves an attachment to its uploader and to members of the
+// conversations it was sent to. Everybody else gets a 404.
+func (app *application) downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveAttachment(w, r, false)
+}
+
+// downloadAttachmentThumbnailHandler serves the thumbnail of an image attachment, with the same
+// access rules as the attachment itself.
+func (app *application) downloadAttachmentThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveAttachment(w, r, true)
+}
+
+func (app *application) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	user := app.contextGetUser(r)
+
+	attachmentID, err := strconv.ParseInt(mux.Vars(r)["attachmentId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid attachment ID")
+		return
+	}
+
+	attachment, err := app.models.Attachments.GetForUser(attachmentID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if thumbnail {
+		if attachment.ThumbnailKey == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.serveMedia(w, r, *attachment.ThumbnailKey, "")
+		return
+	}
+
+	// Only images are shown inline, anything else is downloaded, so that e.g. an uploaded HTML
+	// file can't run in the context of the API.
+	disposition := "attachment"
+	if _, ok := imageContentTypes[attachment.ContentType]; ok {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+
+	app.serveMedia(w, r, attachment.StorageKey, attachment.ContentType)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// createBookmarkHandler saves a message the user can read to their bookmarks, with an optional
+// note and tags.
+func (app *application) createBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	var input struct {
+		ConversationID int      `json:"conversation_id"`
+		MessageID      int      `json:"message_id"`
+		Note           string   `json:"note"`
+		Tags           []string `json:"tags"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bookmark := &models.Bookmark{
+		Note: input.Note,
+		Tags: models.NormalizeTags(input.Tags),
+	}
+
+	v := validator.New()
+	v.Check(input.ConversationID > 0, "conversation_id", "must be a valid conversation ID")
+	v.Check(input.MessageID > 0, "message_id", "must be a valid message ID")
+	if models.ValidateBookmark(v, bookmark); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Bookmarks.Insert(user.ID, input.ConversationID, input.MessageID, bookmark)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrDuplicateBookmark):
+			v.AddError("message_id", "is already bookmarked")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"bookmark": bookmark}, nil)
+}
+
+// getBookmarksHandler returns a page of the user's bookmarks, newest first unless sorted by
+// created_at. They can be searched with query and narrowed down to a single tag.
+func (app *application) getBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readStrings(qs, "query", "")
+	var tag string
+	if tags := models.NormalizeTags([]string{app.readStrings(qs, "tag", "")}); len(tags) > 0 {
+		tag = tags[0]
+	}
+
+	filters := models.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readStrings(qs, "sort", "-created_at"),
+		SortSafeList: []string{"created_at", "-created_at"},
+	}
+
+	v.Check(len(search) <= 256, "query", "must not be more than 256 bytes long")
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bookmarks, metadata, err := app.models.Bookmarks.GetAll(user.ID, search, tag, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEmptySearch):
+			v.AddError("query", "must contain at least one word")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrTooManySearchTerms):
+			v.AddError("query", "must not contain more than 16 terms")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"bookmarks": bookmarks, "metadata": metadata}, nil)
+}
+
+func (app *application) getBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.readBookmark(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"bookmark": bookmark}, nil)
+}
+
+// updateBookmarkHandler changes the note and/or tags of a bookmark.
+func (app *application) updateBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.readBookmark(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.expectedVersionMatches(r, bookmark.Version) {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Note *string  `json:"note"`
+		Tags []string `json:"tags"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Note != nil {
+		bookmark.Note = *input.Note
+	}
+	if input.Tags != nil {
+		bookmark.Tags = models.NormalizeTags(input.Tags)
+	}
+
+	v := validator.New()
+	if models.ValidateBookmark(v, bookmark); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Bookmarks.Update(app.contextGetUser(r).ID, bookmark)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"bookmark": bookmark}, nil)
+}
+
+func (app *application) deleteBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.readBookmark(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Bookmarks.Delete(app.contextGetUser(r).ID, bookmark.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// readBookmark loads the user's bookmark named in the URL, writing an error response and
+// returning false if there is none or the token belongs to someone else.
+func (app *application) readBookmark(w http.ResponseWriter, r *http.Request) (*models.Bookmark, bool) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return nil, false
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["bookmarkId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid bookmark ID")
+		return nil, false
+	}
+
+	bookmark, err := app.models.Bookmarks.Get(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return bookmark, true
+}
@@ -138,6 +138,12 @@ func (app *application) updateChannelHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Channel members can read the channel, but only its owner can change it.
+	if channel.UserId != userID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// Define struct to hold JSON input data
 	var input struct {
 		Name *string `json:"name"`
@@ -12,6 +12,10 @@ type contextKey string
 // context.
 const userContextKey = contextKey("user")
 
+// memberContextKey is used as a key for getting and setting the conversation membership of the
+// current user in the request context.
+const memberContextKey = contextKey("member")
+
 // contextSetUser returns a new copy of the request with the provided User struct added to the
 // context.
 func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
@@ -30,3 +34,21 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 
 	return user
 }
+
+// contextSetMember returns a new copy of the request with the provided conversation membership
+// added to the context.
+func (app *application) contextSetMember(r *http.Request, member *models.Member) *http.Request {
+	ctx := context.WithValue(r.Context(), memberContextKey, member)
+	return r.WithContext(ctx)
+}
+
+// contextGetMember retrieves the conversation membership set by the requireConversationRole
+// middleware. Like contextGetUser it panics if there is no membership in the context.
+func (app *application) contextGetMember(r *http.Request) *models.Member {
+	member, ok := r.Context().Value(memberContextKey).(*models.Member)
+	if !ok {
+		panic("missing member value in request context")
+	}
+
+	return member
+}
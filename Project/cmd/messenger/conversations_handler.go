@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
 	"github.com/gorilla/mux"
 	"net/http"
@@ -14,11 +15,18 @@ import (
 func (app *application) getConversationsHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract userID from URL parameters
 	params := mux.Vars(r)
+	user := app.contextGetUser(r)
 	userID, err := strconv.Atoi(params["userId"])
 	if err != nil {
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
+
+	// The list includes the user's drafts, so nobody else may see it.
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
 	v := validator.New()
 	qs := r.URL.Query()
 	// Extract filters from query parameters
@@ -51,26 +59,51 @@ func (app *application) createConversationHandler(w http.ResponseWriter, r *http
 
 	if int(user.ID) != userID {
 		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
 	}
 
 	var input struct {
-		FriendId int `json:"friend_id"`
+		FriendId      int    `json:"friend_id"`
+		Title         string `json:"title"`
+		Description   string `json:"description"`
+		MentionPolicy string `json:"mention_policy"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
+	if input.MentionPolicy == "" {
+		input.MentionPolicy = models.MentionPolicyIgnore
+	}
+
 	conversation := &models.Conversations{
-		UserId:   userID,
-		FriendId: input.FriendId,
+		UserId:        userID,
+		FriendId:      input.FriendId,
+		Title:         input.Title,
+		Description:   input.Description,
+		MentionPolicy: input.MentionPolicy,
+	}
+
+	v := validator.New()
+	if models.ValidateConversation(v, conversation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	if err := app.models.Conversations.Insert(conversation); err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, models.ErrUnknownUser):
+			v.AddError("friend_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	app.publishMembership(conversation.ConversationId, int64(conversation.UserId), int64(conversation.FriendId))
+
 	// Write the created conversation as JSON response.
 	app.writeJSON(w, http.StatusCreated, envelope{"conversation": conversation}, nil)
 }
@@ -106,20 +139,113 @@ func (app *application) getConversationHandler(w http.ResponseWriter, r *http.Re
 }
 
 func (app *application) deleteConversationHandler(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	user := app.contextGetUser(r)
-	userID, err := strconv.Atoi(params["userId"])
+	// Only the owner gets here, the requireConversationRole middleware has checked it.
+	member := app.contextGetMember(r)
+
+	// Delete the conversation from the database.
+	err := app.models.Conversations.Delete(member.ConversationId)
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	conversationID, err := strconv.Atoi(params["conversationId"])
+
+	app.publishMembership(member.ConversationId)
+
+	// Write success message as JSON response.
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) updateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	conversation, err := app.models.Conversations.Get(int(member.UserId), member.ConversationId)
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, "Invalid conversation ID")
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	// Delete the conversation from the database.
-	err = app.models.Conversations.Delete(int(user.ID), userID, conversationID)
+
+	// If the client sent the version it based its edit on, make sure it still matches the
+	// version of the record in the database before going any further.
+	if !app.expectedVersionMatches(r, conversation.Version) {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Define struct to hold JSON input data
+	var input struct {
+		Title         *string `json:"title"`
+		Description   *string `json:"description"`
+		MessageTTL    *int    `json:"message_ttl"`
+		MentionPolicy *string `json:"mention_policy"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Update conversation fields if they're provided in the input
+	if input.Title != nil {
+		conversation.Title = *input.Title
+	}
+	if input.Description != nil {
+		conversation.Description = *input.Description
+	}
+	previousTTL := conversation.MessageTTL
+	if input.MessageTTL != nil {
+		conversation.MessageTTL = *input.MessageTTL
+	}
+	if input.MentionPolicy != nil {
+		conversation.MentionPolicy = *input.MentionPolicy
+	}
+
+	v := validator.New()
+	if models.ValidateConversation(v, conversation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Let everybody in the conversation know that the disappearing messages timer changed.
+	var notice *models.Messages
+	if conversation.MessageTTL != previousTTL {
+		content := fmt.Sprintf("%s set messages to disappear after %s", member.Name,
+			models.MessageTTLs[conversation.MessageTTL])
+		if conversation.MessageTTL == 0 {
+			content = fmt.Sprintf("%s turned off disappearing messages", member.Name)
+		}
+		notice = app.systemMessage(member, content)
+	}
+
+	// Update the conversation, checking for edit conflicts with concurrent requests.
+	err = app.models.Conversations.Update(conversation, app.models.Messages, notice)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"conversation": conversation}, nil)
+}
+
+func (app *application) updateConversationAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	conversation, err := app.models.Conversations.Get(int(member.UserId), member.ConversationId)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -130,6 +256,62 @@ func (app *application) deleteConversationHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Write success message as JSON response.
-	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+	if !app.expectedVersionMatches(r, conversation.Version) {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Read and store the new image before touching the database record.
+	data, contentType, err := app.readImageUpload(w, r, "avatar")
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key, err := app.storeMedia(r.Context(), data, contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous := conversation.Avatar
+	conversation.Avatar = key
+
+	err = app.models.Conversations.Update(conversation, app.models.Messages, nil)
+	if err != nil {
+		// The new file is no longer referenced by anything, so clean it up.
+		if err := app.removeMedia(r.Context(), key); err != nil {
+			app.logError(r, err)
+		}
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.removeMedia(r.Context(), previous); err != nil {
+		app.logError(r, err)
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"conversation": conversation}, nil)
+}
+
+func (app *application) getConversationAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	conversation, err := app.models.Conversations.Get(int(member.UserId), member.ConversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.serveMedia(w, r, conversation.Avatar, "")
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+// getDraftHandler returns the member's draft in the conversation, or null if there is none.
+func (app *application) getDraftHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	draft, err := app.models.Drafts.Get(member.UserId, member.ConversationId)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"draft": draft}, nil)
+}
+
+// saveDraftHandler replaces the member's draft in the conversation. Saving an empty draft
+// discards it.
+func (app *application) saveDraftHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		Content   string `json:"content"`
+		ReplyToID *int   `json:"reply_to_message_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	draft := &models.Draft{
+		ConversationId: member.ConversationId,
+		Content:        input.Content,
+		ReplyTo:        input.ReplyToID,
+	}
+
+	v := validator.New()
+	if models.ValidateDraft(v, draft); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if draft.Empty() {
+		err = app.models.Drafts.Delete(member.UserId, member.ConversationId)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.publishDraft(member.UserId, member.ConversationId, nil)
+		app.writeJSON(w, http.StatusOK, envelope{"draft": nil}, nil)
+		return
+	}
+
+	err = app.models.Drafts.Save(member.UserId, draft)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrInvalidReply):
+			v.AddError("reply_to_message_id", "must be a message in the same conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishDraft(member.UserId, member.ConversationId, draft)
+	app.writeJSON(w, http.StatusOK, envelope{"draft": draft}, nil)
+}
+
+func (app *application) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	err := app.models.Drafts.Delete(member.UserId, member.ConversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishDraft(member.UserId, member.ConversationId, nil)
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// publishDraft lets the user's other devices know that their draft changed. A nil draft means
+// that it was discarded.
+func (app *application) publishDraft(userID int64, conversationID int, draft *models.Draft) {
+	app.events.Publish(events.Event{
+		Type:           events.TypeDraftUpdated,
+		ConversationId: conversationID,
+		Data:           envelope{"draft": draft},
+		UserIds:        []int64{userID},
+	})
+}
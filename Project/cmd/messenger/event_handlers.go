@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/gorilla/mux"
+)
+
+// eventsHandler streams the events of every conversation the user belongs to as server-sent
+// events, until the client goes away or the server shuts down.
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	// The stream is long-lived, so lift the server's write timeout for this response.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sub := app.events.Subscribe(64)
+	defer app.events.Unsubscribe(sub)
+
+	// The conversations the user belongs to are loaded once, after subscribing so that no
+	// membership change is missed, and kept up to date from membership events. Events can be
+	// dropped for slow subscribers, so they are reloaded with every heartbeat too.
+	conversations, err := app.memberConversations(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.done:
+			return
+		case <-heartbeat.C:
+			if reloaded, err := app.memberConversations(user.ID); err != nil {
+				app.logError(r, err)
+			} else {
+				conversations = reloaded
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if !e.For(user.ID) {
+				continue
+			}
+
+			// A membership event for the whole conversation only matters to its members.
+			if e.Type == events.TypeMembership && (e.UserIds != nil || conversations[e.ConversationId]) {
+				_, err := app.models.Members.Get(e.ConversationId, user.ID)
+				switch {
+				case err == nil:
+					conversations[e.ConversationId] = true
+				case errors.Is(err, models.ErrRecordNotFound):
+					if !conversations[e.ConversationId] {
+						continue
+					}
+					delete(conversations, e.ConversationId)
+				default:
+					app.logError(r, err)
+					continue
+				}
+			} else if !conversations[e.ConversationId] {
+				// Only forward events of conversations the user is a member of.
+				continue
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				app.logError(r, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// memberConversations returns the set of conversations the user belongs to.
+func (app *application) memberConversations(userID int64) (map[int]bool, error) {
+	ids, err := app.models.Members.ConversationIds(userID)
+	if err != nil {
+		return nil, err
+	}
+	conversations := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		conversations[id] = true
+	}
+	return conversations, nil
+}
+
+// publishMembership tells the event streams of the users that they joined or left the
+// conversation, so that they start or stop forwarding its events. Without users, it is meant for
+// every member, e.g. when the conversation is deleted.
+func (app *application) publishMembership(conversationID int, userIDs ...int64) {
+	app.events.Publish(events.Event{
+		Type:           events.TypeMembership,
+		ConversationId: conversationID,
+		Data:           map[string]interface{}{"user_ids": userIDs},
+		UserIds:        userIDs,
+	})
+}
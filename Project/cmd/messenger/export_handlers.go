@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// conversationExporter writes a conversation transcript in one particular format. begin is called
+// once before the messages, message once per message in chronological order, and end once after
+// the last message.
+type conversationExporter interface {
+	contentType() string
+	extension() string
+	begin(w io.Writer, conversation *models.Conversations, participants []*models.Member) error
+	message(w io.Writer, message *models.ExportedMessage) error
+	end(w io.Writer) error
+}
+
+// exporters maps the supported values of the "format" query parameter to their exporters.
+var exporters = map[string]conversationExporter{
+	"ndjson": ndjsonExporter{},
+	"html":   htmlExporter{},
+	"text":   textExporter{},
+}
+
+// exportTimeLayout is the human-readable timestamp layout used by the HTML and text exports.
+const exportTimeLayout = "2006-01-02 15:04:05 MST"
+
+// formatExportTime renders a message timestamp in UTC, falling back to the raw value if it can't
+// be parsed.
+func formatExportTime(timestamp string) string {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.UTC().Format(exportTimeLayout)
+}
+
+// exportConversationHandler streams every message of a conversation as NDJSON, HTML or plain
+// text. It is reachable by conversation members, and by staff with the "conversation:export"
+// permission through a separate route. A member's export leaves out the messages they deleted for
+// themselves, the staff export doesn't.
+func (app *application) exportConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conversationID, err := strconv.Atoi(mux.Vars(r)["conversationId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	// Only the member route names a user, and requireConversationRole has loaded them.
+	var userID int64
+	if _, ok := mux.Vars(r)["userId"]; ok {
+		userID = app.contextGetMember(r).UserId
+	}
+
+	format := app.readStrings(r.URL.Query(), "format", "ndjson")
+	v := validator.New()
+	if v.Check(validator.In(format, "ndjson", "html", "text"), "format", "must be one of ndjson, html or text"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	exporter := exporters[format]
+
+	conversation, err := app.models.Conversations.GetByID(conversationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	participants, err := app.models.Members.GetAll(conversationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Large conversations can take a while to stream, so lift the server's write timeout for
+	// this response. The export stops as soon as the client goes away.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", exporter.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%d.%s"`,
+		conversationID, exporter.extension()))
+	w.WriteHeader(http.StatusOK)
+
+	// From here on the status code has been sent, so errors can only be logged.
+	bw := bufio.NewWriter(w)
+	if err := exporter.begin(bw, conversation, participants); err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	count := 0
+	err = app.models.Messages.Export(r.Context(), conversationID, userID, func(message *models.ExportedMessage) error {
+		if err := exporter.message(bw, message); err != nil {
+			return err
+		}
+
+		count++
+		if count%100 == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	if err := exporter.end(bw); err != nil {
+		app.logError(r, err)
+		return
+	}
+	if err := bw.Flush(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// ndjsonExporter writes one JSON document per line: the conversation and its participants first,
+// then one line per message.
+type ndjsonExporter struct{}
+
+func (ndjsonExporter) contentType() string { return "application/x-ndjson" }
+func (ndjsonExporter) extension() string   { return "ndjson" }
+
+func (ndjsonExporter) begin(w io.Writer, conversation *models.Conversations, participants []*models.Member) error {
+	return json.NewEncoder(w).Encode(envelope{"conversation": conversation, "participants": participants})
+}
+
+func (ndjsonExporter) message(w io.Writer, message *models.ExportedMessage) error {
+	return json.NewEncoder(w).Encode(envelope{"message": message})
+}
+
+func (ndjsonExporter) end(io.Writer) error { return nil }
+
+// textExporter writes a plain text transcript.
+type textExporter struct{}
+
+func (textExporter) contentType() string { return "text/plain; charset=utf-8" }
+func (textExporter) extension() string   { return "txt" }
+
+func (textExporter) begin(w io.Writer, conversation *models.Conversations, participants []*models.Member) error {
+	title := conversation.Title
+	if title == "" {
+		title = fmt.Sprintf("Conversation %d", conversation.ConversationId)
+	}
+
+	names := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		names = append(names, participant.Name)
+	}
+
+	_, err := fmt.Fprintf(w, "%s\nParticipants: %s\nExported: %s\n\n", title, strings.Join(names, ", "),
+		time.Now().UTC().Format(exportTimeLayout))
+	return err
+}
+
+func (textExporter) message(w io.Writer, message *models.ExportedMessage) error {
+	var err error
+	if message.Type == models.MessageTypeSystem {
+		_, err = fmt.Fprintf(w, "[%s] * %s\n", formatExportTime(message.Timestamp), message.Content)
+	} else {
+		_, err = fmt.Fprintf(w, "[%s] %s: %s\n", formatExportTime(message.Timestamp), message.SenderName, message.Content)
+	}
+	return err
+}
+
+func (textExporter) end(io.Writer) error { return nil }
+
+// htmlExporter writes a self-contained HTML page, with inline styles and no external resources.
+type htmlExporter struct{}
+
+var (
+	htmlExportHeader = template.Must(template.New("header").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; color: #222; }
+header { border-bottom: 1px solid #ddd; margin-bottom: 1rem; }
+.message { margin: 0.5rem 0; }
+.meta { color: #777; font-size: 0.85em; }
+.sender { font-weight: bold; }
+.system { color: #777; font-style: italic; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+{{with .Description}}<p>{{.}}</p>{{end}}
+<p class="meta">Participants: {{range $i, $p := .Participants}}{{if $i}}, {{end}}{{$p.Name}}{{end}}</p>
+<p class="meta">Exported: {{.Exported}}</p>
+</header>
+<main>
+`))
+
+	htmlExportMessage = template.Must(template.New("message").Parse(`{{if .System}}<div class="message system"><span class="meta">{{.Time}}</span> {{.Content}}</div>
+{{else}}<div class="message"><span class="meta">{{.Time}}</span> <span class="sender">{{.Sender}}</span><div class="content">{{.Content}}</div></div>
+{{end}}`))
+)
+
+func (htmlExporter) contentType() string { return "text/html; charset=utf-8" }
+func (htmlExporter) extension() string   { return "html" }
+
+func (htmlExporter) begin(w io.Writer, conversation *models.Conversations, participants []*models.Member) error {
+	title := conversation.Title
+	if title == "" {
+		title = fmt.Sprintf("Conversation %d", conversation.ConversationId)
+	}
+
+	return htmlExportHeader.Execute(w, map[string]interface{}{
+		"Title":        title,
+		"Description":  conversation.Description,
+		"Participants": participants,
+		"Exported":     time.Now().UTC().Format(exportTimeLayout),
+	})
+}
+
+func (htmlExporter) message(w io.Writer, message *models.ExportedMessage) error {
+	return htmlExportMessage.Execute(w, map[string]interface{}{
+		"System":  message.Type == models.MessageTypeSystem,
+		"Time":    formatExportTime(message.Timestamp),
+		"Sender":  message.SenderName,
+		"Content": message.Content,
+	})
+}
+
+func (htmlExporter) end(w io.Writer) error {
+	_, err := io.WriteString(w, "</main>\n</body>\n</html>\n")
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
 	"github.com/gorilla/mux"
@@ -201,3 +202,39 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// readTime reads an RFC 3339 timestamp or a YYYY-MM-DD date from the URL query string. Nil is
+// returned if the key is missing, and an error is recorded in the validator if the value can't be
+// parsed. Dates are midnight UTC, unless endOfDay is set, in which case the following midnight is
+// returned so the whole day is included by an exclusive upper bound.
+func (app *application) readTime(qs url.Values, key string, endOfDay bool, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		return nil
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t
+}
+
+// expectedVersionMatches reports whether the optional X-Expected-Version request header matches
+// the given record version. If the client didn't send the header it always returns true.
+func (app *application) expectedVersionMatches(r *http.Request, version int) bool {
+	expected := r.Header.Get("X-Expected-Version")
+	if expected == "" {
+		return true
+	}
+
+	return strconv.Itoa(version) == expected
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// readInviteTarget returns the conversation or channel an invite route refers to, depending on
+// which of the {conversationId} and {channelId} URL parameters is present.
+func (app *application) readInviteTarget(r *http.Request) (models.InviteTarget, error) {
+	params := mux.Vars(r)
+
+	if param, ok := params["conversationId"]; ok {
+		conversationID, err := strconv.Atoi(param)
+		if err != nil {
+			return models.InviteTarget{}, errors.New("invalid conversation id parameter")
+		}
+		return models.InviteTarget{ConversationId: &conversationID}, nil
+	}
+
+	channelID, err := strconv.Atoi(params["channelId"])
+	if err != nil {
+		return models.InviteTarget{}, errors.New("invalid channel id parameter")
+	}
+	return models.InviteTarget{ChannelId: &channelID}, nil
+}
+
+func (app *application) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	target, err := app.readInviteTarget(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		ExpiresInSeconds *int `json:"expires_in_seconds"`
+		MaxUses          *int `json:"max_uses"`
+		RequiresApproval bool `json:"requires_approval"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invite := &models.Invite{
+		InviteTarget:     target,
+		CreatedBy:        user.ID,
+		MaxUses:          input.MaxUses,
+		RequiresApproval: input.RequiresApproval,
+	}
+
+	v := validator.New()
+	if input.ExpiresInSeconds != nil {
+		if models.ValidateInviteLifetime(v, *input.ExpiresInSeconds); v.Valid() {
+			expiry := time.Now().Add(time.Duration(*input.ExpiresInSeconds) * time.Second)
+			invite.Expiry = &expiry
+		}
+	}
+	if models.ValidateInvite(v, invite); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invites.New(invite)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// This is the only time the plaintext code is available, the database only has its hash.
+	app.writeJSON(w, http.StatusCreated, envelope{"invite": invite}, nil)
+}
+
+func (app *application) getInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := app.readInviteTarget(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invites, err := app.models.Invites.GetAll(target)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invites": invites}, nil)
+}
+
+func (app *application) revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := app.readInviteTarget(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	inviteID, err := strconv.ParseInt(mux.Vars(r)["inviteId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+
+	err = app.models.Invites.Revoke(target, inviteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) getInviteRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := app.readInviteTarget(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	inviteID, err := strconv.ParseInt(mux.Vars(r)["inviteId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+
+	requests, err := app.models.Invites.GetRequests(target, inviteID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"requests": requests}, nil)
+}
+
+func (app *application) decideInviteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	target, err := app.readInviteTarget(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	inviteID, err := strconv.ParseInt(params["inviteId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+	requesterID, err := strconv.ParseInt(params["requesterId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid requester ID")
+		return
+	}
+
+	var input struct {
+		Approve *bool `json:"approve"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Approve != nil, "approve", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invites.DecideRequest(target, inviteID, requesterID, *input.Approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrInvalidInvite):
+			v.AddError("invite", "the invite has been revoked, has expired or has no uses left")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if *input.Approve && target.ConversationId != nil {
+		app.publishMembership(*target.ConversationId, requesterID)
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) previewInviteHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+
+	v := validator.New()
+	if models.ValidateInviteCode(v, code); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	preview, err := app.models.Invites.Preview(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidInvite):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"invite": preview}, nil)
+}
+
+func (app *application) joinInviteHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	code := mux.Vars(r)["code"]
+
+	v := validator.New()
+	if models.ValidateInviteCode(v, code); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	target, status, err := app.models.Invites.Join(code, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidInvite):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if status == models.JoinJoined && target.ConversationId != nil {
+		app.publishMembership(*target.ConversationId, user.ID)
+	}
+
+	// A join request that still has to be approved is accepted, but not done yet.
+	statusCode := http.StatusOK
+	if status == models.JoinPending {
+		statusCode = http.StatusAccepted
+	}
+
+	app.writeJSON(w, statusCode, envelope{"status": status, "target": target}, nil)
+}
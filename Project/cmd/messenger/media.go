@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/blobstore"
+)
+
+// imageContentTypes maps the image types we accept for uploaded media to the file extension
+// they are stored with.
+var imageContentTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	// errUnsupportedMedia is returned when an uploaded file isn't one of the accepted image types.
+	errUnsupportedMedia = errors.New("file must be a PNG, JPEG, GIF or WebP image")
+)
+
+// upload is a file read from a multipart form.
+type upload struct {
+	data        []byte
+	filename    string
+	contentType string
+}
+
+// readUpload reads a single file from the multipart form field with the given name. The request
+// body is limited to maxBytes, and the content type is sniffed from the file contents rather than
+// trusted from the client.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) (*upload, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return nil, fmt.Errorf("file must not be larger than %d bytes", maxBytes)
+		case errors.Is(err, http.ErrMissingFile):
+			return nil, fmt.Errorf("multipart field %q must be provided", field)
+		default:
+			return nil, err
+		}
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// http.DetectContentType only looks at the first 512 bytes, which is enough to recognise
+	// all of the formats we treat specially.
+	return &upload{
+		data:        data,
+		filename:    header.Filename,
+		contentType: http.DetectContentType(data),
+	}, nil
+}
+
+// readImageUpload reads a single image from the multipart form field with the given name, limited
+// to the configured maximum media size.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, string, error) {
+	upload, err := app.readUpload(w, r, field, app.config.media.maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, ok := imageContentTypes[upload.contentType]; !ok {
+		return nil, "", errUnsupportedMedia
+	}
+
+	return upload.data, upload.contentType, nil
+}
+
+// newMediaKey returns a random blob store key below the given prefix.
+func newMediaKey(prefix, extension string) (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return prefix + "/" + hex.EncodeToString(randomBytes) + extension, nil
+}
+
+// storeMedia writes an image to the blob store under a random name and returns the key the file
+// can later be served with.
+func (app *application) storeMedia(ctx context.Context, data []byte, contentType string) (string, error) {
+	key, err := newMediaKey("avatars", imageContentTypes[contentType])
+	if err != nil {
+		return "", err
+	}
+
+	return key, app.blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// removeMedia deletes a previously stored media file. A missing file is not treated as an error.
+func (app *application) removeMedia(ctx context.Context, key string) error {
+	if key == "" {
+		return nil
+	}
+	return app.blobs.Delete(ctx, key)
+}
+
+// serveMedia writes a stored media file to the response, or a 404 if it doesn't exist. The
+// content type recorded by the caller takes precedence over what the blob store reports.
+func (app *application) serveMedia(w http.ResponseWriter, r *http.Request, key, contentType string) {
+	if key == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	object, err := app.blobs.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blobstore.ErrNotFound), errors.Is(err, blobstore.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Body.Close()
+
+	if contentType == "" {
+		contentType = object.ContentType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if object.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	}
+	if !object.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, object.Body); err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+func (app *application) getConversationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	members, err := app.models.Members.GetAll(member.ConversationId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+}
+
+func (app *application) addConversationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.UserID > 0, "user_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// New members always join as regular members, the owner can promote them afterwards.
+	newMember := &models.Member{
+		ConversationId: member.ConversationId,
+		UserId:         input.UserID,
+		Role:           models.RoleMember,
+	}
+
+	err = app.models.Members.Insert(newMember)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateMember):
+			v.AddError("user_id", "user is already a member of this conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrUnknownUser):
+			v.AddError("user_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishMembership(member.ConversationId, newMember.UserId)
+
+	app.writeJSON(w, http.StatusCreated, envelope{"member": newMember}, nil)
+}
+
+func (app *application) removeConversationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	memberID, err := strconv.ParseInt(mux.Vars(r)["memberId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid member ID")
+		return
+	}
+
+	target, err := app.models.Members.Get(member.ConversationId, memberID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Anybody but the owner may leave a conversation on their own. Removing someone else needs
+	// admin rights, and only the owner can remove another admin.
+	switch {
+	case target.Role == models.RoleOwner:
+		v := validator.New()
+		v.AddError("member", "the owner must transfer ownership before leaving the conversation")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case target.UserId == member.UserId:
+	case !models.RoleAtLeast(member.Role, models.RoleAdmin),
+		target.Role == models.RoleAdmin && member.Role != models.RoleOwner:
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Members.Delete(member.ConversationId, target.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishMembership(member.ConversationId, target.UserId)
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) updateConversationMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	memberID, err := strconv.ParseInt(mux.Vars(r)["memberId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid member ID")
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if memberID == member.UserId {
+		v.AddError("member", "the owner can't change their own role, transfer ownership instead")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target := &models.Member{
+		ConversationId: member.ConversationId,
+		UserId:         memberID,
+		Role:           input.Role,
+	}
+
+	err = app.models.Members.UpdateRole(target)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Read the member back so the response contains the name and join date.
+	target, err = app.models.Members.Get(member.ConversationId, memberID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"member": target}, nil)
+}
+
+func (app *application) transferConversationOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.UserID != member.UserId, "user_id", "must be another member of the conversation")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Members.TransferOwnership(member.ConversationId, member.UserId, input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("user_id", "must be a member of the conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	members, err := app.models.Members.GetAll(member.ConversationId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// getMentionsHandler returns a page of the messages mentioning the user, newest first, together
+// with the number of unread mentions. With ?unread=true only unread mentions are listed.
+func (app *application) getMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	unreadOnly, err := strconv.ParseBool(app.readStrings(qs, "unread", "false"))
+	v.Check(err == nil, "unread", "must be true or false")
+
+	filters := models.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-created_at",
+		SortSafeList: []string{"-created_at"},
+	}
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mentions, metadata, err := app.models.Mentions.GetAll(user.ID, unreadOnly, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	unread, err := app.models.Mentions.UnreadCount(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	messages := make([]*models.Messages, len(mentions))
+	for i, mention := range mentions {
+		messages[i] = mention.Message
+	}
+	if err := app.loadMessageDetails(userID, messages); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"mentions": mentions, "unread_count": unread, "metadata": metadata}, nil)
+}
+
+// markMentionsReadHandler marks the user's mentions as read. Without a body every mention is
+// marked, otherwise only those in conversation_id and/or the given message_ids.
+func (app *application) markMentionsReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	var input struct {
+		ConversationID int   `json:"conversation_id"`
+		MessageIDs     []int `json:"message_ids"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	v.Check(input.ConversationID >= 0, "conversation_id", "must be a valid conversation ID")
+	v.Check(len(input.MessageIDs) <= 100, "message_ids", "must not contain more than 100 messages")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if _, err := app.models.Mentions.MarkRead(user.ID, input.ConversationID, input.MessageIDs); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	unread, err := app.models.Mentions.UnreadCount(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"unread_count": unread}, nil)
+}
+
+// updateHandleHandler sets the handle the user can be mentioned by, or removes it when the handle
+// is null.
+func (app *application) updateHandleHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	var input struct {
+		Handle *string `json:"handle"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Handle != nil {
+		*input.Handle = strings.TrimPrefix(*input.Handle, "@")
+		if models.ValidateHandle(v, *input.Handle); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	user.Handle = input.Handle
+	err = app.models.Users.SetHandle(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateHandle):
+			v.AddError("handle", "is already taken")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+}
+
+// notifyMentions lets the users mentioned for the first time in a message know about it.
+func (app *application) notifyMentions(message *models.Messages) {
+	if len(message.Mentioned) == 0 {
+		return
+	}
+
+	conversationID, _ := strconv.Atoi(message.ConversationId)
+	app.events.Publish(events.Event{
+		Type:           events.TypeMentionCreated,
+		ConversationId: conversationID,
+		Data: envelope{
+			"message_id": message.MessageId,
+			"sender_id":  message.SenderId,
+		},
+		UserIds: message.Mentioned,
+	})
+}
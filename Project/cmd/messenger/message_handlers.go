@@ -2,44 +2,77 @@ package main
 
 import (
 	"errors"
+	"github.com/KarenMirzayan/Project/pkg/events"
 	"github.com/KarenMirzayan/Project/pkg/messenger/models"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/KarenMirzayan/Project/pkg/richtext"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 import "time"
 
-func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Request) { //TODO: get sender_id from url
-	params := mux.Vars(r)
-	conversationID := params["conversationId"]
-	userID, err := strconv.Atoi(params["userId"])
+// createMessageHandler sends a message to the conversation on behalf of the authenticated member.
+func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+	conversationID := strconv.Itoa(member.ConversationId)
+	userID := int(member.UserId)
 
+	// Define a struct to hold JSON input data
+	var input struct {
+		// SenderID is still accepted from older clients but ignored, the sender is the member.
+		SenderID      int               `json:"sender_id"`
+		Content       string            `json:"content"`
+		Format        string            `json:"format"`
+		Entities      []richtext.Entity `json:"entities"`
+		ReplyToID     *int              `json:"reply_to_message_id"`
+		AttachmentIDs []int64           `json:"attachment_ids"`
+		UploadIDs     []string          `json:"upload_ids"`
+		// ClientMessageID makes retrying the request safe, like the Idempotency-Key header.
+		ClientMessageID string `json:"client_message_id"`
+	}
+
+	// Read JSON input into the struct
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		log.Println(err)
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	// Define a struct to hold JSON input data
-	var input struct {
-		SenderID int    `json:"sender_id"`
-		Content  string `json:"content"`
+	v := validator.New()
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	switch {
+	case idempotencyKey == "":
+		idempotencyKey = input.ClientMessageID
+	case input.ClientMessageID != "" && input.ClientMessageID != idempotencyKey:
+		v.AddError("client_message_id", "must match the Idempotency-Key header")
+	}
+	if models.ValidateIdempotencyKey(v, "client_message_id", idempotencyKey); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	// Read JSON input into the struct
-	err = app.readJSON(w, r, &input)
+	// Finished resumable uploads are sent as the attachments they were turned into.
+	uploaded, err := app.models.Uploads.AttachmentIDs(int64(userID), input.UploadIDs)
 	if err != nil {
-		log.Println(err)
-		app.errorResponse(w, r, http.StatusBadRequest, "Invalid request payload")
+		switch {
+		case errors.Is(err, models.ErrInvalidUpload):
+			v.AddError("upload_ids", "must only contain your own finished uploads")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	input.AttachmentIDs = append(input.AttachmentIDs, uploaded...)
 
-	// Extract conversation_id from the request
-	if conversationID == "" {
-		app.errorResponse(w, r, http.StatusBadRequest, "conversation_id is required")
+	if models.ValidateAttachmentIDs(v, input.AttachmentIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
@@ -50,13 +83,49 @@ func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Requ
 	message := &models.Messages{
 		ConversationId: conversationID,
 		SenderId:       userID,
-		Content:        input.Content,
 		Timestamp:      timestamp,
+		ReplyTo:        input.ReplyToID,
+		AttachmentIds:  input.AttachmentIDs,
+		IdempotencyKey: idempotencyKey,
+	}
+	message.SetContent(input.Content, input.Format, input.Entities)
+
+	validateFormat(v, input.Format, input.Entities)
+	if models.ValidateMessage(v, message); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	// Insert the new message into the database
 	err = app.models.Messages.Insert(message)
 	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrInvalidReply):
+			v.AddError("reply_to_message_id", "must be a message in the same conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidAttachment):
+			v.AddError("attachment_ids", "must only contain your own uploaded attachments")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidMention):
+			v.AddError("content", "must not mention users outside the conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A retry gets the message sent the first time, everyone was already told about it.
+	if !message.Replayed {
+		app.notifyMentions(message)
+		if message.DraftCleared {
+			app.publishDraft(member.UserId, member.ConversationId, nil)
+		}
+	}
+
+	if err := app.loadMessageDetails(userID, []*models.Messages{message}); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -84,6 +153,13 @@ func (app *application) getMessageHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The route only matches numeric user IDs.
+	viewerID, _ := strconv.Atoi(userID)
+	if err := app.loadMessageDetails(viewerID, []*models.Messages{message}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Respond with the JSON representation of the message
 	app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
 }
@@ -107,9 +183,17 @@ func (app *application) updateMessageHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Members can only edit their own messages, not even admins can change what others wrote.
+	if message.SenderId != int(app.contextGetMember(r).UserId) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// Define struct to hold JSON input data
 	var input struct {
-		Content *string `json:"content"`
+		Content  *string           `json:"content"`
+		Format   string            `json:"format"`
+		Entities []richtext.Entity `json:"entities"`
 	}
 
 	// Read JSON input into struct
@@ -119,53 +203,104 @@ func (app *application) updateMessageHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Update message fields if they're provided in the input
+	// Update message fields if they're provided in the input. New content comes with its own
+	// formatting, if any.
 	if input.Content != nil {
-		message.Content = *input.Content
+		message.SetContent(*input.Content, input.Format, input.Entities)
 	}
 
 	// Validate the updated message
 	v := validator.New()
+	validateFormat(v, input.Format, input.Entities)
 	if models.ValidateMessage(v, message); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Update the message in the database
-	err = app.models.Messages.Update(message)
+	err = app.models.Messages.Update(message, app.config.messages.editWindow)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrEditWindowClosed):
+			v.AddError("content", "message can no longer be edited")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidMention):
+			v.AddError("content", "must not mention users outside the conversation")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	app.notifyMentions(message)
+
 	// Respond with the JSON representation of the updated message
 	app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
 }
 
+// deleteMessageHandler deletes a message either for the caller only (?for=me) or for everyone
+// (?for=everyone, the default). Deleting for everyone leaves a tombstone in place of the message.
 func (app *application) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract parameters from the request URL
 	params := mux.Vars(r)
 	userID := params["userId"]
 	conversationID := params["conversationId"]
 	messageID := params["messageId"]
+	member := app.contextGetMember(r)
+
+	mode := app.readStrings(r.URL.Query(), "for", "everyone")
+	v := validator.New()
+	if v.Check(validator.In(mode, "me", "everyone"), "for", "must be either me or everyone"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	// Delete the message from the database
-	err := app.models.Messages.Delete(conversationID, userID, messageID)
+	var err error
+	if mode == "me" {
+		err = app.models.Messages.DeleteForMe(conversationID, userID, messageID)
+	} else {
+		var message *models.Messages
+		message, err = app.models.Messages.Get(conversationID, userID, messageID)
+		if err == nil {
+			// Senders can take back their own messages for a limited time. Admins and the owner
+			// can remove any message to moderate the conversation.
+			switch {
+			case message.SenderId == int(member.UserId):
+				err = app.models.Messages.DeleteForEveryone(conversationID, userID, messageID, app.config.messages.deleteWindow)
+			case models.RoleAtLeast(member.Role, models.RoleAdmin):
+				err = app.models.Messages.DeleteAny(conversationID, messageID)
+			default:
+				app.notPermittedResponse(w, r)
+				return
+			}
+		}
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrDeleteWindowClosed):
+			v.AddError("for", "message can no longer be deleted for everyone")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if mode == "everyone" {
+		// The route only matches numeric message IDs.
+		id, _ := strconv.Atoi(messageID)
+		app.events.Publish(events.Event{
+			Type:           events.TypeMessagesDeleted,
+			ConversationId: member.ConversationId,
+			Data:           map[string]interface{}{"message_ids": []int{id}, "reason": "deleted"},
+		})
+	}
+
 	// Respond with success message
 	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
 }
@@ -196,10 +331,12 @@ func (app *application) getMessagesList(w http.ResponseWriter, r *http.Request)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readStrings(qs, "sort", "timestamp")
 	input.Filters.SortSafeList = []string{
-		"-timestamp", "timestamp",
+		"-timestamp", "timestamp", "-rank", "rank",
 	}
 
 	// Validate input parameters and filters
+	v.Check(len(input.Query) <= 256, "query", "must not be more than 256 bytes long")
+	v.Check(input.Query != "" || !strings.HasSuffix(input.Filters.Sort, "rank"), "sort", "rank can only be used with a query")
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -208,6 +345,20 @@ func (app *application) getMessagesList(w http.ResponseWriter, r *http.Request)
 	// Get messages based on input parameters and filters
 	messages, metadata, err := app.models.Messages.GetAll(userID, conversationID, input.Query, input.Filters)
 	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEmptySearch):
+			v.AddError("query", "must contain at least one word")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrTooManySearchTerms):
+			v.AddError("query", "must not contain more than 16 terms")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.loadMessageDetails(userID, messages); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -215,3 +366,193 @@ func (app *application) getMessagesList(w http.ResponseWriter, r *http.Request)
 	// Respond with JSON containing messages and metadata
 	app.writeJSON(w, http.StatusOK, envelope{"messages": messages, "metadata": metadata}, nil)
 }
+
+// getMessageThreadHandler returns a message together with a page of its replies. The root is
+// returned even if it was deleted, as a placeholder without content.
+func (app *application) getMessageThreadHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+	params := mux.Vars(r)
+	userID := int(member.UserId)
+	conversationID := member.ConversationId
+	messageID, err := strconv.Atoi(params["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	var filters models.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "timestamp"
+	filters.SortSafeList = []string{"timestamp"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	root, err := app.models.Messages.Get(params["conversationId"], params["userId"], params["messageId"])
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	replies, metadata, err := app.models.Messages.GetReplies(userID, conversationID, messageID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.loadMessageDetails(userID, append([]*models.Messages{root}, replies...)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"root": root, "replies": replies, "metadata": metadata}, nil)
+}
+
+// getMessageRevisionsHandler returns the edit history of a message to the participants of its
+// conversation.
+func (app *application) getMessageRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	revisions, err := app.models.Messages.GetRevisions(member.ConversationId, messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+}
+
+// forwardMessagesHandler copies messages of the conversation into other conversations of the
+// member. All copies are created or none of them.
+func (app *application) forwardMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		MessageIDs      []int `json:"message_ids"`
+		ConversationIDs []int `json:"conversation_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateForward(v, input.MessageIDs, input.ConversationIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, err := app.models.Messages.Forward(member.UserId, member.ConversationId, input.MessageIDs, input.ConversationIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidForward):
+			v.AddError("message_ids", "must only contain visible text messages of this conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidForwardTarget):
+			v.AddError("conversation_ids", "must only contain conversations you are a member of")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidMention):
+			v.AddError("conversation_ids", "must not include conversations that reject mentions of non-members")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, message := range messages {
+		app.notifyMentions(message)
+	}
+
+	if err := app.loadMessageDetails(int(member.UserId), messages); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"messages": messages}, nil)
+}
+
+// validateFormat checks the format a message was sent in. Markdown brings its own formatting, so
+// entities can only be sent along with plain content.
+func validateFormat(v *validator.Validator, format string, entities []richtext.Entity) {
+	v.Check(format == "" || validator.In(format, models.FormatPlain, models.FormatMarkdown), "format",
+		"must be either plain or markdown")
+	v.Check(format != models.FormatMarkdown || entities == nil, "entities", "must not be provided with markdown")
+}
+
+// loadMessageDetails fills in the reaction summaries and poll results, as seen by the user, and
+// the attachments of a page of messages, using one query per kind for the whole page.
+func (app *application) loadMessageDetails(userID int, messages []*models.Messages) error {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.MessageId)
+	}
+
+	summaries, err := app.models.Reactions.Summaries(userID, ids)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := app.models.Attachments.ForMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	if err := app.models.Polls.LoadResults(int64(userID), messages); err != nil {
+		return err
+	}
+
+	previews, err := app.models.LinkPreviews.ForMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := app.models.Receipts.Statuses(int64(userID), ids)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		message.Reactions = summaries[message.MessageId]
+		message.Attachments = attachments[message.MessageId]
+		message.LinkPreview = previews[message.MessageId]
+		message.DeliveryStatus = statuses[message.MessageId]
+	}
+	return nil
+}
+
+// systemMessage builds a server generated message for the conversation of the member, on behalf
+// of that member. It is sent together with the change it announces.
+func (app *application) systemMessage(member *models.Member, content string) *models.Messages {
+	return &models.Messages{
+		ConversationId: strconv.Itoa(member.ConversationId),
+		SenderId:       int(member.UserId),
+		Content:        content,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+		Type:           models.MessageTypeSystem,
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"github.com/KarenMirzayan/Project/pkg/messenger/models"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -133,3 +135,99 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 	// Wrap this with the requireActivatedUser middleware before returning
 	return app.requireActivatedUser(fn)
 }
+
+// requireConversationRole checks that the authenticated user is the user in the {userId} URL
+// parameter, that they are a member of the conversation in {conversationId}, and that their role
+// in it is at least the given one. The membership is stored in the request context so handlers
+// can make finer-grained decisions. It is layered on top of the "conversation:write" permission.
+func (app *application) requireConversationRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		user := app.contextGetUser(r)
+
+		userID, err := strconv.Atoi(params["userId"])
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		conversationID, err := strconv.Atoi(params["conversationId"])
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid conversation ID")
+			return
+		}
+
+		if int(user.ID) != userID {
+			app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+			return
+		}
+
+		// Users who don't belong to the conversation get a 404, so we don't leak which
+		// conversations exist.
+		member, err := app.models.Members.Get(conversationID, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !models.RoleAtLeast(member.Role, role) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		r = app.contextSetMember(r, member)
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requirePermissions("conversation:write", fn)
+}
+
+// requireChannelOwner checks that the authenticated user is the user in the {userId} URL
+// parameter and that they own the channel in {channelId}.
+func (app *application) requireChannelOwner(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		user := app.contextGetUser(r)
+
+		userID, err := strconv.Atoi(params["userId"])
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		channelID, err := strconv.Atoi(params["channelId"])
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid channel ID")
+			return
+		}
+
+		if int(user.ID) != userID {
+			app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+			return
+		}
+
+		channel, err := app.models.Channels.Get(userID, channelID)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if channel.UserId != userID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requirePermissions("conversation:write", fn)
+}
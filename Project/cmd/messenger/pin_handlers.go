@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// canPin reports whether the member may pin and unpin messages. Both participants of a one-on-one
+// conversation can, in groups it is up to the admins and the owner.
+func (app *application) canPin(member *models.Member) (bool, error) {
+	if models.RoleAtLeast(member.Role, models.RoleAdmin) {
+		return true, nil
+	}
+
+	count, err := app.models.Members.Count(member.ConversationId)
+	if err != nil {
+		return false, err
+	}
+	return count <= 2, nil
+}
+
+func (app *application) pinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	allowed, err := app.canPin(member)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// Pinning a message twice doesn't announce it again.
+	notice := app.systemMessage(member, fmt.Sprintf("%s pinned a message", member.Name))
+	pinned, err := app.models.Pins.Pin(member.ConversationId, messageID, member.UserId, app.config.messages.maxPins,
+		app.models.Messages, notice)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrTooManyPins):
+			v := validator.New()
+			v.AddError("message", fmt.Sprintf("a conversation can't have more than %d pinned messages", app.config.messages.maxPins))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if pinned {
+		app.events.Publish(events.Event{
+			Type:           events.TypeMessagePinned,
+			ConversationId: member.ConversationId,
+			Data:           map[string]interface{}{"message_id": messageID, "pinned_by": member.UserId},
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) unpinMessageHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	allowed, err := app.canPin(member)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Pins.Unpin(member.ConversationId, messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.events.Publish(events.Event{
+		Type:           events.TypeMessageUnpinned,
+		ConversationId: member.ConversationId,
+		Data:           map[string]interface{}{"message_id": messageID, "unpinned_by": member.UserId},
+	})
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// getPinnedMessagesHandler lists the pinned messages of the conversation in the order they were
+// pinned.
+func (app *application) getPinnedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	pins, err := app.models.Pins.GetAll(member.UserId, member.ConversationId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	messages := make([]*models.Messages, len(pins))
+	for i, pin := range pins {
+		messages[i] = pin.Message
+	}
+	if err := app.loadMessageDetails(int(member.UserId), messages); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"pinned_messages": pins}, nil)
+}
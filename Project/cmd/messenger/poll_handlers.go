@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// createPollHandler posts a poll message into the conversation.
+func (app *application) createPollHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		Question       string     `json:"question"`
+		Options        []string   `json:"options"`
+		MultipleChoice bool       `json:"multiple_choice"`
+		Anonymous      bool       `json:"anonymous"`
+		ClosesAt       *time.Time `json:"closes_at"`
+		ReplyToID      *int       `json:"reply_to_message_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	poll := models.NewPoll(input.Question, input.Options, input.MultipleChoice, input.Anonymous, input.ClosesAt)
+
+	v := validator.New()
+	if models.ValidatePoll(v, poll, time.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	message := &models.Messages{
+		ConversationId: strconv.Itoa(member.ConversationId),
+		SenderId:       int(member.UserId),
+		Content:        poll.Question,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+		Type:           models.MessageTypePoll,
+		ReplyTo:        input.ReplyToID,
+		Poll:           poll,
+	}
+
+	err = app.models.Messages.Insert(message)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrInvalidReply):
+			v.AddError("reply_to_message_id", "must be a message in the same conversation")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.loadMessageDetails(int(member.UserId), []*models.Messages{message}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"message": message}, nil)
+}
+
+// votePollHandler replaces the member's vote on a poll with the given options, an empty list
+// takes the vote back. The poll is returned with its updated results.
+func (app *application) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	var input struct {
+		OptionIDs []int `json:"option_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.OptionIDs != nil, "option_ids", "must be provided")
+	v.Check(validator.Unique(input.OptionIDs), "option_ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Polls.Vote(member.UserId, member.ConversationId, messageID, input.OptionIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrPollClosed):
+			v.AddError("option_ids", "the poll is closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrInvalidVote):
+			v.AddError("option_ids", "must be options of the poll, and only one unless it is multiple choice")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.events.Publish(events.Event{
+		Type:           events.TypePollVoted,
+		ConversationId: member.ConversationId,
+		Data:           map[string]interface{}{"message_id": messageID},
+	})
+
+	message, err := app.models.Messages.Get(strconv.Itoa(member.ConversationId), strconv.FormatInt(member.UserId, 10),
+		strconv.Itoa(messageID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.loadMessageDetails(int(member.UserId), []*models.Messages{message}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+}
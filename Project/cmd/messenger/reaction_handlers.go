@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// readReactionParams reads the message ID and emoji of the reaction routes. It writes an error
+// response and returns ok=false if either is invalid.
+func (app *application) readReactionParams(w http.ResponseWriter, r *http.Request) (messageID int, emoji string, ok bool) {
+	params := mux.Vars(r)
+	messageID, err := strconv.Atoi(params["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return 0, "", false
+	}
+
+	emoji = params["emoji"]
+	v := validator.New()
+	if models.ValidateEmoji(v, emoji); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return 0, "", false
+	}
+
+	return messageID, emoji, true
+}
+
+func (app *application) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, emoji, ok := app.readReactionParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Reactions.Add(member.ConversationId, messageID, member.UserId, emoji)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrTooManyReactions):
+			v := validator.New()
+			v.AddError("emoji", fmt.Sprintf("a message can't have more than %d different reactions", models.MaxDistinctReactions))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+func (app *application) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, emoji, ok := app.readReactionParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Reactions.Remove(member.ConversationId, messageID, member.UserId, emoji)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// getReactorsHandler lists the users who reacted to a message with a particular emoji.
+func (app *application) getReactorsHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, emoji, ok := app.readReactionParams(w, r)
+	if !ok {
+		return
+	}
+
+	var filters models.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "created_at"
+	filters.SortSafeList = []string{"created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reactors, metadata, err := app.models.Reactions.GetReactors(member.ConversationId, messageID, emoji, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"users": reactors, "metadata": metadata}, nil)
+}
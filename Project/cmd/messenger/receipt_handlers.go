@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// ackReceiptsHandler acknowledges a batch of messages the user's device received or showed, from
+// any of the user's conversations. Messages listed as read count as delivered too. The sender of
+// each message whose receipt changed is told about it.
+func (app *application) ackReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	var input struct {
+		Delivered []int `json:"delivered"`
+		Read      []int `json:"read"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateAck(v, input.Delivered, input.Read); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	acked, err := app.models.Receipts.Ack(user.ID, input.Delivered, input.Read)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, receipt := range acked {
+		app.events.Publish(events.Event{
+			Type:           events.TypeMessageReceipt,
+			ConversationId: receipt.ConversationId,
+			Data:           receipt,
+			UserIds:        []int64{receipt.SenderId},
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"receipts": acked}, nil)
+}
+
+// getMessageReceiptsHandler returns the delivery state of one of the member's messages for each
+// recipient, together with its overall delivery status. Only the sender can see them.
+func (app *application) getMessageReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	receipts, status, err := app.models.Receipts.ForMessage(member.UserId, member.ConversationId, messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"delivery_status": status, "receipts": receipts}, nil)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
 	"github.com/gorilla/mux"
 )
 
@@ -27,20 +28,68 @@ func (app *application) routes() http.Handler {
 	// Get a conversation
 	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}", app.getConversationHandler).Methods("GET")
 	// Delete a specific conversation
-	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}", app.requirePermissions("conversation:write", app.deleteConversationHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}", app.requireConversationRole(models.RoleOwner, app.deleteConversationHandler)).Methods("DELETE")
+	// Update conversation title and description
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}", app.requireConversationRole(models.RoleAdmin, app.updateConversationHandler)).Methods("PUT")
+	// Upload or fetch the conversation avatar
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/avatar", app.requireConversationRole(models.RoleAdmin, app.updateConversationAvatarHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/avatar", app.requireConversationRole(models.RoleMember, app.getConversationAvatarHandler)).Methods("GET")
+	// Conversation members and roles
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/members", app.requireConversationRole(models.RoleMember, app.getConversationMembersHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/members", app.requireConversationRole(models.RoleAdmin, app.addConversationMemberHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/members/{memberId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.removeConversationMemberHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/members/{memberId:[0-9]+}/role", app.requireConversationRole(models.RoleOwner, app.updateConversationMemberRoleHandler)).Methods("PUT")
+	// Transfer ownership of a conversation to another member
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/owner", app.requireConversationRole(models.RoleOwner, app.transferConversationOwnershipHandler)).Methods("PUT")
 	// Get all conversations (with filtering)
 	v1.HandleFunc("/users/{userId:[0-9]+}/conversations", app.getConversationsHandler).Methods("GET")
 
 	//Create message in conversation
-	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages", app.createMessageHandler).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages", app.requireConversationRole(models.RoleMember, app.createMessageHandler)).Methods("POST")
 	// Get a specific message
 	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}", app.getMessageHandler).Methods("GET")
 	//Update a specific message
-	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}", app.updateMessageHandler).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.updateMessageHandler)).Methods("PUT")
 	// Delete a specific message
-	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}", app.deleteMessageHandler).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.deleteMessageHandler)).Methods("DELETE")
+	// Get the edit history of a message
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/revisions", app.requireConversationRole(models.RoleMember, app.getMessageRevisionsHandler)).Methods("GET")
+	// Get a message and its replies
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/thread", app.requireConversationRole(models.RoleMember, app.getMessageThreadHandler)).Methods("GET")
+	// Delivery state of a message for each recipient, for its sender
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/receipts", app.requireConversationRole(models.RoleMember, app.getMessageReceiptsHandler)).Methods("GET")
+	// React to a message, take a reaction back, or list who reacted with an emoji
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/reactions/{emoji}", app.requireConversationRole(models.RoleMember, app.addReactionHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/reactions/{emoji}", app.requireConversationRole(models.RoleMember, app.removeReactionHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/reactions/{emoji}", app.requireConversationRole(models.RoleMember, app.getReactorsHandler)).Methods("GET")
+
+	// Post polls and vote on them
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/polls", app.requireConversationRole(models.RoleMember, app.createPollHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/votes", app.requireConversationRole(models.RoleMember, app.votePollHandler)).Methods("PUT")
+
+	// Pin and unpin messages, and list the pinned messages of a conversation
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/pin", app.requireConversationRole(models.RoleMember, app.pinMessageHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/{messageId:[0-9]+}/pin", app.requireConversationRole(models.RoleMember, app.unpinMessageHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/pins", app.requireConversationRole(models.RoleMember, app.getPinnedMessagesHandler)).Methods("GET")
 	// Get all messages of conversation
 	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages", app.getMessagesList).Methods("GET")
+	// Messages sent, edited or deleted since a change sequence number, for clients keeping a local copy
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/sync", app.requireConversationRole(models.RoleMember, app.syncConversationHandler)).Methods("GET")
+
+	// The member's draft in a conversation, shared between their devices
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/draft", app.requireConversationRole(models.RoleMember, app.getDraftHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/draft", app.requireConversationRole(models.RoleMember, app.saveDraftHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/draft", app.requireConversationRole(models.RoleMember, app.deleteDraftHandler)).Methods("DELETE")
+
+	// Forward messages into other conversations of the member
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/messages/forward", app.requireConversationRole(models.RoleMember, app.forwardMessagesHandler)).Methods("POST")
+
+	// Schedule messages to be sent later, and list, edit or cancel them until they are sent
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/scheduled-messages", app.requireConversationRole(models.RoleMember, app.createScheduledMessageHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/scheduled-messages", app.requireConversationRole(models.RoleMember, app.getScheduledMessagesHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/scheduled-messages/{scheduledId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.getScheduledMessageHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/scheduled-messages/{scheduledId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.updateScheduledMessageHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/scheduled-messages/{scheduledId:[0-9]+}", app.requireConversationRole(models.RoleMember, app.cancelScheduledMessageHandler)).Methods("DELETE")
 
 	v1.HandleFunc("/users/{userId:[0-9]+}/channels", app.requirePermissions("conversation:write", app.createChannelHandler)).Methods("POST")
 	// Get a specific message
@@ -51,6 +100,61 @@ func (app *application) routes() http.Handler {
 	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}", app.requirePermissions("conversation:write", app.deleteChannelHandler)).Methods("DELETE")
 	v1.HandleFunc("/users/{userId:[0-9]+}/channels", app.getChannelsList).Methods("GET")
 
+	// Invite links for conversations (managed by admins) and channels (managed by the owner)
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/invites", app.requireConversationRole(models.RoleAdmin, app.createInviteHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/invites", app.requireConversationRole(models.RoleAdmin, app.getInvitesHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/invites/{inviteId:[0-9]+}", app.requireConversationRole(models.RoleAdmin, app.revokeInviteHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/invites/{inviteId:[0-9]+}/requests", app.requireConversationRole(models.RoleAdmin, app.getInviteRequestsHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/invites/{inviteId:[0-9]+}/requests/{requesterId:[0-9]+}", app.requireConversationRole(models.RoleAdmin, app.decideInviteRequestHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}/invites", app.requireChannelOwner(app.createInviteHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}/invites", app.requireChannelOwner(app.getInvitesHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}/invites/{inviteId:[0-9]+}", app.requireChannelOwner(app.revokeInviteHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}/invites/{inviteId:[0-9]+}/requests", app.requireChannelOwner(app.getInviteRequestsHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/channels/{channelId:[0-9]+}/invites/{inviteId:[0-9]+}/requests/{requesterId:[0-9]+}", app.requireChannelOwner(app.decideInviteRequestHandler)).Methods("PUT")
+	// Preview an invite and join through it
+	v1.HandleFunc("/invites/{code:[A-Z2-7]+}", app.previewInviteHandler).Methods("GET")
+	v1.HandleFunc("/invites/{code:[A-Z2-7]+}/join", app.requireActivatedUser(app.joinInviteHandler)).Methods("POST")
+
+	// Export a whole conversation as NDJSON, HTML or plain text
+	v1.HandleFunc("/users/{userId:[0-9]+}/conversations/{conversationId:[0-9]+}/export", app.requireConversationRole(models.RoleMember, app.exportConversationHandler)).Methods("GET")
+	v1.HandleFunc("/conversations/{conversationId:[0-9]+}/export", app.requirePermissions("conversation:export", app.exportConversationHandler)).Methods("GET")
+
+	// Upload attachments and download them, or their thumbnails
+	v1.HandleFunc("/attachments", app.requirePermissions("conversation:write", app.uploadAttachmentHandler)).Methods("POST")
+	v1.HandleFunc("/attachments/{attachmentId:[0-9]+}", app.requireActivatedUser(app.downloadAttachmentHandler)).Methods("GET")
+	v1.HandleFunc("/attachments/{attachmentId:[0-9]+}/thumbnail", app.requireActivatedUser(app.downloadAttachmentThumbnailHandler)).Methods("GET")
+
+	// Resumable uploads (tus 1.0), a finished upload is turned into an attachment
+	v1.HandleFunc("/uploads", app.uploadOptionsHandler).Methods("OPTIONS")
+	v1.HandleFunc("/uploads", app.requireTusVersion(app.requirePermissions("conversation:write", app.createUploadHandler))).Methods("POST")
+	v1.HandleFunc("/uploads/{uploadId:[0-9a-f]{32}}", app.requireTusVersion(app.requirePermissions("conversation:write", app.headUploadHandler))).Methods("HEAD")
+	v1.HandleFunc("/uploads/{uploadId:[0-9a-f]{32}}", app.requireTusVersion(app.requirePermissions("conversation:write", app.patchUploadHandler))).Methods("PATCH")
+	v1.HandleFunc("/uploads/{uploadId:[0-9a-f]{32}}", app.requireTusVersion(app.requirePermissions("conversation:write", app.deleteUploadHandler))).Methods("DELETE")
+
+	// Change personal settings, e.g. whether forwarded messages name the user
+	v1.HandleFunc("/users/{userId:[0-9]+}/settings", app.requireActivatedUser(app.updateUserSettingsHandler)).Methods("PATCH")
+
+	// Set the handle users are mentioned by, and list and acknowledge their mentions
+	v1.HandleFunc("/users/{userId:[0-9]+}/handle", app.requireActivatedUser(app.updateHandleHandler)).Methods("PUT")
+	v1.HandleFunc("/users/{userId:[0-9]+}/mentions", app.requireActivatedUser(app.getMentionsHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/mentions/read", app.requireActivatedUser(app.markMentionsReadHandler)).Methods("POST")
+
+	// Acknowledge delivery and reading of messages in batches
+	v1.HandleFunc("/users/{userId:[0-9]+}/receipts", app.requireActivatedUser(app.ackReceiptsHandler)).Methods("POST")
+
+	// Bookmark messages, and list, search, annotate and remove bookmarks
+	v1.HandleFunc("/users/{userId:[0-9]+}/bookmarks", app.requireActivatedUser(app.createBookmarkHandler)).Methods("POST")
+	v1.HandleFunc("/users/{userId:[0-9]+}/bookmarks", app.requireActivatedUser(app.getBookmarksHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/bookmarks/{bookmarkId:[0-9]+}", app.requireActivatedUser(app.getBookmarkHandler)).Methods("GET")
+	v1.HandleFunc("/users/{userId:[0-9]+}/bookmarks/{bookmarkId:[0-9]+}", app.requireActivatedUser(app.updateBookmarkHandler)).Methods("PATCH")
+	v1.HandleFunc("/users/{userId:[0-9]+}/bookmarks/{bookmarkId:[0-9]+}", app.requireActivatedUser(app.deleteBookmarkHandler)).Methods("DELETE")
+
+	// Search the messages of all of the user's conversations
+	v1.HandleFunc("/users/{userId:[0-9]+}/search", app.requireActivatedUser(app.searchHandler)).Methods("GET")
+
+	// Stream conversation events (server-sent events)
+	v1.HandleFunc("/users/{userId:[0-9]+}/events", app.requireActivatedUser(app.eventsHandler)).Methods("GET")
+
 	v1.HandleFunc("/users", app.registerUserHandler).Methods("POST")
 	v1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
 	v1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// createScheduledMessageHandler schedules a message of the member for a later time. Whether the
+// reply target, attachments and mentions are still valid is checked when the message is sent.
+func (app *application) createScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	var input struct {
+		Content       string    `json:"content"`
+		SendAt        time.Time `json:"send_at"`
+		ReplyToID     *int      `json:"reply_to_message_id"`
+		AttachmentIDs []int64   `json:"attachment_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	scheduled := &models.ScheduledMessage{
+		ConversationId: member.ConversationId,
+		SenderId:       member.UserId,
+		Content:        input.Content,
+		ReplyTo:        input.ReplyToID,
+		AttachmentIds:  input.AttachmentIDs,
+		SendAt:         input.SendAt,
+	}
+
+	v := validator.New()
+	if models.ValidateScheduledMessage(v, scheduled, time.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Scheduled.Insert(scheduled)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"scheduled_message": scheduled}, nil)
+}
+
+// getScheduledMessagesHandler lists the member's messages in the conversation that are still
+// waiting to be sent or failed to be sent, in the order they are due.
+func (app *application) getScheduledMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters := models.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "send_at",
+		SortSafeList: []string{"send_at"},
+	}
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scheduled, metadata, err := app.models.Scheduled.GetAll(member.ConversationId, member.UserId, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"scheduled_messages": scheduled, "metadata": metadata}, nil)
+}
+
+func (app *application) getScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := app.readScheduledMessage(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"scheduled_message": scheduled}, nil)
+}
+
+// updateScheduledMessageHandler changes a message that hasn't been sent yet.
+func (app *application) updateScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := app.readScheduledMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.expectedVersionMatches(r, scheduled.Version) {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Content       *string    `json:"content"`
+		SendAt        *time.Time `json:"send_at"`
+		ReplyToID     *int       `json:"reply_to_message_id"`
+		AttachmentIDs []int64    `json:"attachment_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if scheduled.Status != models.ScheduleStatusScheduled {
+		v.AddError("status", "message is no longer scheduled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Content != nil {
+		scheduled.Content = *input.Content
+	}
+	if input.SendAt != nil {
+		scheduled.SendAt = *input.SendAt
+	}
+	if input.ReplyToID != nil {
+		scheduled.ReplyTo = input.ReplyToID
+	}
+	if input.AttachmentIDs != nil {
+		scheduled.AttachmentIds = input.AttachmentIDs
+	}
+
+	if models.ValidateScheduledMessage(v, scheduled, time.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Scheduled.Update(scheduled)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"scheduled_message": scheduled}, nil)
+}
+
+// cancelScheduledMessageHandler cancels a message that hasn't been sent yet.
+func (app *application) cancelScheduledMessageHandler(w http.ResponseWriter, r *http.Request) {
+	scheduled, ok := app.readScheduledMessage(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Scheduled.Cancel(scheduled)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			v := validator.New()
+			v.AddError("status", "message is no longer scheduled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"scheduled_message": scheduled}, nil)
+}
+
+// readScheduledMessage loads the member's scheduled message named in the URL, writing an error
+// response and returning false if there is none.
+func (app *application) readScheduledMessage(w http.ResponseWriter, r *http.Request) (*models.ScheduledMessage, bool) {
+	member := app.contextGetMember(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["scheduledId"], 10, 64)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid scheduled message ID")
+		return nil, false
+	}
+
+	scheduled, err := app.models.Scheduled.Get(member.ConversationId, member.UserId, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return scheduled, true
+}
+
+// dispatchScheduledMessages sends the scheduled messages that are due, in batches.
+func (app *application) dispatchScheduledMessages() {
+	for {
+		sent, handled, err := app.models.Scheduled.DispatchDue(app.models.Messages, app.config.scheduler.batchSize)
+		for _, message := range sent {
+			app.notifyMentions(message)
+		}
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"worker": "scheduler"})
+			return
+		}
+
+		// A short batch means we have caught up, the rest waits for the next tick.
+		if handled < app.config.scheduler.batchSize {
+			return
+		}
+
+		select {
+		case <-app.done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
+)
+
+// searchHandler searches the messages of every conversation the user belongs to. Besides the
+// query, results can be narrowed down with sender_id, from and to (dates or timestamps, to being
+// exclusive) and has=attachment, which can also be written as has:attachment inside the query.
+// Pages are requested with the next_cursor of the previous page.
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters models.SearchFilters
+	filters.Query, filters.HasAttachment = extractHasAttachment(app.readStrings(qs, "query", ""))
+	if has := app.readStrings(qs, "has", ""); has != "" {
+		v.Check(has == "attachment", "has", "must be attachment")
+		filters.HasAttachment = true
+	}
+	if qs.Get("sender_id") != "" {
+		senderID := app.readInt(qs, "sender_id", 0, v)
+		v.Check(senderID > 0, "sender_id", "must be a valid user ID")
+		filters.SenderId = &senderID
+	}
+	filters.From = app.readTime(qs, "from", false, v)
+	filters.To = app.readTime(qs, "to", true, v)
+	filters.Limit = app.readInt(qs, "page_size", 20, v)
+
+	if cursor := qs.Get("cursor"); cursor != "" {
+		filters.Cursor, err = models.DecodeSearchCursor(cursor)
+		v.Check(err == nil, "cursor", "must be a cursor returned by a previous search")
+	}
+
+	v.Check(len(filters.Query) <= 256, "query", "must not be more than 256 bytes long")
+	v.Check(filters.Query != "" || filters.SenderId != nil || filters.From != nil || filters.To != nil ||
+		filters.HasAttachment, "query", "must be provided unless another filter is used")
+	v.Check(filters.From == nil || filters.To == nil || filters.From.Before(*filters.To), "to", "must be after from")
+	v.Check(filters.Limit > 0, "page_size", "must be greater than 0")
+	v.Check(filters.Limit <= 100, "page_size", "must be a maximum of 100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, err := app.models.Messages.Search(userID, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEmptySearch):
+			v.AddError("query", "must contain at least one word")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrTooManySearchTerms):
+			v.AddError("query", "must not contain more than 16 terms")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var messages []*models.Messages
+	for _, group := range results.Groups {
+		messages = append(messages, group.Messages...)
+	}
+	if err := app.loadMessageDetails(userID, messages); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"search": results}, nil)
+}
+
+// extractHasAttachment removes has:attachment operators from a search query and reports whether
+// there were any.
+func extractHasAttachment(query string) (string, bool) {
+	found := false
+	fields := strings.Fields(query)
+	kept := fields[:0]
+	for _, field := range fields {
+		if strings.EqualFold(field, "has:attachment") {
+			found = true
+			continue
+		}
+		kept = append(kept, field)
+	}
+	if !found {
+		return query, false
+	}
+	return strings.Join(kept, " "), true
+}
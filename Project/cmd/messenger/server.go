@@ -48,6 +48,10 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Tell the background workers and open event streams to stop, so that they don't hold
+		// up the shutdown.
+		close(app.done)
+
 		// Create a context with a 5-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
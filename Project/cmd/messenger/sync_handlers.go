@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+// Limits on the number of changes returned by a single sync request.
+const (
+	defaultSyncLimit = 100
+	maxSyncLimit     = 500
+)
+
+// syncCursor is the position of a sync that is paged through the changes between Since and Upto.
+// After is the change sequence number of the last change already returned.
+type syncCursor struct {
+	ConversationId int
+	Since          int64
+	After          int64
+	Upto           int64
+}
+
+// encode turns the cursor into the opaque continuation token handed to clients.
+func (c syncCursor) encode() string {
+	s := fmt.Sprintf("%d.%d.%d.%d", c.ConversationId, c.Since, c.After, c.Upto)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// decodeSyncCursor parses a continuation token. It reports false if the token is malformed.
+func decodeSyncCursor(token string) (syncCursor, bool) {
+	var c syncCursor
+	s, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, false
+	}
+	n, err := fmt.Sscanf(string(s), "%d.%d.%d.%d", &c.ConversationId, &c.Since, &c.After, &c.Upto)
+	if err != nil || n != 4 || c.Since < 0 || c.After < c.Since || c.Upto < c.After {
+		return c, false
+	}
+	return c, true
+}
+
+// syncConversationHandler returns the messages of the conversation that were sent, edited or
+// deleted since the change sequence number since_seq, so that clients can bring a local copy up to
+// date. Each message is reported once, as it is now, deleted ones as tombstones.
+//
+// The changes are returned in pages of at most limit messages. As long as there are more, the
+// response has a continuation token to pass instead of since_seq to get the next page. The last
+// page has none, its next_seq is the since_seq of the next sync.
+func (app *application) syncConversationHandler(w http.ResponseWriter, r *http.Request) {
+	member := app.contextGetMember(r)
+	qs := r.URL.Query()
+
+	v := validator.New()
+	limit := app.readInt(qs, "limit", defaultSyncLimit, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= maxSyncLimit, "limit", "must be a maximum of 500")
+
+	var cursor syncCursor
+	if token := qs.Get("continuation"); token != "" {
+		var ok bool
+		cursor, ok = decodeSyncCursor(token)
+		if !ok || cursor.ConversationId != member.ConversationId {
+			v.AddError("continuation", "must be a token returned by a previous sync of the conversation")
+		}
+	} else {
+		since := app.readInt(qs, "since_seq", 0, v)
+		v.Check(since >= 0, "since_seq", "must not be negative")
+
+		// Changes made while the client pages through are left for its next sync, so that the
+		// pages fit together.
+		upto, err := app.models.Messages.LastChangeSeq(member.ConversationId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(int64(since) <= upto, "since_seq", "must not be ahead of the conversation")
+		cursor = syncCursor{ConversationId: member.ConversationId, Since: int64(since), After: int64(since), Upto: upto}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// One more than the limit tells whether there is another page.
+	changes, err := app.models.Messages.Changes(member.UserId, member.ConversationId, cursor.Since, cursor.After,
+		cursor.Upto, limit+1)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+
+	var messages []*models.Messages
+	for _, change := range changes {
+		if change.Message != nil {
+			messages = append(messages, change.Message)
+		}
+	}
+	if err := app.loadMessageDetails(int(member.UserId), messages); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := envelope{"changes": changes, "next_seq": cursor.Upto}
+	if more {
+		cursor.After = changes[len(changes)-1].ChangeSeq
+		response["continuation"] = cursor.encode()
+	}
+	app.writeJSON(w, http.StatusOK, response, nil)
+}
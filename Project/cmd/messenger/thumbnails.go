@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// thumbnailSize is the maximum width and height of a generated thumbnail.
+	thumbnailSize = 320
+
+	// maxThumbnailPixels bounds the size of images we are willing to decode, so that a small
+	// file that claims huge dimensions can't exhaust memory.
+	maxThumbnailPixels = 40_000_000
+)
+
+// imageInfo describes an uploaded image and its thumbnail.
+type imageInfo struct {
+	width, height int
+	thumbnail     []byte
+	thumbnailType string
+}
+
+// makeThumbnail decodes a PNG, JPEG or GIF image and scales it down to fit in a square of
+// thumbnailSize pixels. ok is false for anything it can't or won't decode, e.g. WebP images,
+// which the standard library has no decoder for.
+func makeThumbnail(data []byte, contentType string) (info *imageInfo, ok bool) {
+	decode := map[string]func([]byte) (image.Image, error){
+		"image/png":  func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) },
+		"image/jpeg": func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) },
+		"image/gif":  func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) },
+	}[contentType]
+	if decode == nil {
+		return nil, false
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+		return nil, false
+	}
+
+	src, err := decode(data)
+	if err != nil {
+		return nil, false
+	}
+
+	thumbnail := scaleDown(src, thumbnailSize)
+
+	// Photos compress far better as JPEG, everything else keeps its transparency as PNG.
+	var buf bytes.Buffer
+	info = &imageInfo{width: config.Width, height: config.Height}
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 80})
+		info.thumbnailType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, thumbnail)
+		info.thumbnailType = "image/png"
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	info.thumbnail = buf.Bytes()
+	return info, true
+}
+
+// scaleDown shrinks the image to fit in a size x size square, keeping its aspect ratio. Every
+// destination pixel is the average of the source pixels it covers, which is good enough for
+// thumbnails and avoids the aliasing of nearest-neighbour sampling.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if w > h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	// Work on a plain RGBA copy, so pixel access doesn't go through the image.Image interface.
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+uint64(p[0]), g+uint64(p[1]), b+uint64(p[2]), a+uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
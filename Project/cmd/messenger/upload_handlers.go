@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+	"github.com/gorilla/mux"
+)
+
+// The resumable upload endpoints implement the core tus 1.0 protocol with the creation,
+// expiration and termination extensions, see https://tus.io/protocols/resumable-upload. A
+// finished upload becomes an attachment, and can be sent with a message through its upload ID.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+// tusHeaders sets the headers that every tus response carries.
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// requireTusVersion rejects requests made for a protocol version we don't speak.
+func (app *application) requireTusVersion(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tusHeaders(w)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			app.errorResponse(w, r, http.StatusPreconditionFailed, "unsupported tus version")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// uploadOptionsHandler advertises the protocol version, extensions and size limit.
+func (app *application) uploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(app.config.attachments.maxBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUploadHandler starts a new upload of Upload-Length bytes. The file name can be passed as
+// "filename" in Upload-Metadata.
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		app.errorResponse(w, r, http.StatusBadRequest, "Upload-Length must be a non-negative integer")
+		return
+	}
+	if length > app.config.attachments.maxBytes {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("upload must not be larger than %d bytes", app.config.attachments.maxBytes))
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	upload, err := app.models.Uploads.New(user.ID, length, cleanFilename(metadata["filename"]),
+		app.config.uploads.expiry, app.config.attachments.quota)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrQuotaExceeded):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "storage quota exceeded")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// An empty file is complete as soon as it is created.
+	if upload.Complete() {
+		if err := app.finishUpload(r.Context(), upload); err != nil {
+			app.uploadErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", "/api/v1/uploads/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headUploadHandler reports how many bytes of an upload have been received, so the client knows
+// where to resume.
+func (app *application) headUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.readResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.AttachmentId != nil {
+		w.Header().Set("Attachment-Id", strconv.FormatInt(*upload.AttachmentId, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUploadHandler appends a chunk at Upload-Offset. Whatever arrives before the client goes
+// away is kept, so the next attempt can resume from there.
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.errorResponse(w, r, http.StatusBadRequest, "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	upload, ok := app.readResumableUpload(w, r)
+	if !ok {
+		return
+	}
+	if offset != upload.Received {
+		app.errorResponse(w, r, http.StatusConflict, "Upload-Offset doesn't match the current offset")
+		return
+	}
+
+	// Chunks can take a long time on slow networks, so lift the server's read timeout. The write
+	// timeout runs while the body is read too, so it is pushed back past the read one.
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(10 * time.Minute)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(11 * time.Minute)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	remaining := upload.Length - upload.Received
+	data, readErr := io.ReadAll(io.LimitReader(r.Body, remaining))
+	if len(data) > 0 {
+		// Store the chunk in the background context, a client that disconnected midway still
+		// gets to keep what it sent.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		key, err := upload.NewPartKey(offset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if err := app.blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// A concurrent request for the same offset stored its chunk under another key, so only
+		// this attempt's chunk is removed if it lost.
+		err = app.models.Uploads.AddPart(upload, offset, int64(len(data)), key)
+		if err != nil {
+			app.removeBlobs([]string{key})
+			switch {
+			case errors.Is(err, models.ErrEditConflict):
+				app.errorResponse(w, r, http.StatusConflict, "Upload-Offset doesn't match the current offset")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	if readErr != nil {
+		app.badRequestResponse(w, r, readErr)
+		return
+	}
+
+	// This also retries turning the upload into an attachment if that failed on the last chunk.
+	if upload.Complete() && upload.AttachmentId == nil {
+		if err := app.finishUpload(r.Context(), upload); err != nil {
+			app.uploadErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.AttachmentId != nil {
+		w.Header().Set("Attachment-Id", strconv.FormatInt(*upload.AttachmentId, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUploadHandler terminates an upload and throws away what was received.
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.Delete(mux.Vars(r)["uploadId"], user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.removeUploadParts(upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readResumableUpload looks up the upload in the URL for the authenticated user. It writes an error
+// response and returns ok=false if it doesn't exist or has expired.
+func (app *application) readResumableUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.Get(mux.Vars(r)["uploadId"], user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return upload, true
+}
+
+// finishUpload joins the chunks of a complete upload and stores the result as an attachment.
+func (app *application) finishUpload(ctx context.Context, upload *models.Upload) error {
+	data := make([]byte, 0, upload.Length)
+	for _, key := range upload.Parts {
+		object, err := app.blobs.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		data, err = appendAll(data, object.Body)
+		object.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	attachment, err := app.storeAttachment(ctx, upload.OwnerId, upload.ID, upload.Filename, http.DetectContentType(data), data)
+	if err != nil {
+		return err
+	}
+
+	parts := *upload
+	if err := app.models.Uploads.SetAttachment(upload, attachment.ID); err != nil {
+		return err
+	}
+	app.removeUploadParts(&parts)
+	return nil
+}
+
+// appendAll appends everything read from r to data.
+func appendAll(data []byte, r io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(data)
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// removeUploadParts deletes the stored chunks of an upload.
+func (app *application) removeUploadParts(upload *models.Upload) {
+	app.removeBlobs(upload.Parts)
+}
+
+// uploadErrorResponse reports a failure to turn a finished upload into an attachment.
+func (app *application) uploadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, models.ErrQuotaExceeded):
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "storage quota exceeded")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma separated pairs of a key and an
+// optional base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata contains an empty key")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value for %q is not valid base64", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// reapExpiredUploads deletes uploads whose expiry has passed, together with their chunks.
+func (app *application) reapExpiredUploads() {
+	for {
+		uploads, err := app.models.Uploads.DeleteExpired(app.config.reaper.batchSize)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"worker": "uploads"})
+			return
+		}
+
+		for _, upload := range uploads {
+			app.removeUploadParts(upload)
+		}
+
+		if len(uploads) < app.config.reaper.batchSize {
+			return
+		}
+
+		select {
+		case <-app.done:
+			return
+		default:
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"github.com/KarenMirzayan/Project/pkg/messenger/models"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -159,3 +161,46 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 }
+
+// updateUserSettingsHandler changes the personal settings of the user. Only the settings present
+// in the request body are changed.
+func (app *application) updateUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if int(user.ID) != userID {
+		app.errorResponse(w, r, http.StatusUnauthorized, "Wrong token")
+		return
+	}
+
+	var input struct {
+		AllowForwardAttribution *bool `json:"allow_forward_attribution"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.AllowForwardAttribution != nil {
+		user.AllowForwardAttribution = *input.AllowForwardAttribution
+	}
+
+	err = app.models.Users.UpdateSettings(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/events"
+	"github.com/KarenMirzayan/Project/pkg/messenger/models"
+)
+
+// background runs fn in a goroutine that is tracked by the application's WaitGroup, so that
+// graceful shutdown waits for it to finish. Panics are recovered and logged.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// runPeriodically calls fn every interval in the background until the application shuts down.
+func (app *application) runPeriodically(interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
+
+// startWorkers starts the long-running background workers of the application.
+func (app *application) startWorkers() {
+	app.runPeriodically(app.config.reaper.interval, app.reapExpiredMessages)
+	app.runPeriodically(app.config.reaper.interval, app.reapExpiredUploads)
+	app.runPeriodically(app.config.reaper.interval, app.reapIdempotencyKeys)
+	app.runPeriodically(app.config.scheduler.interval, app.dispatchScheduledMessages)
+	app.runPeriodically(app.config.linkPreviews.interval, app.generateLinkPreviews)
+}
+
+// reapExpiredMessages deletes expired disappearing messages in batches, and publishes one
+// deletion event per affected conversation.
+func (app *application) reapExpiredMessages() {
+	for {
+		deleted, err := app.models.Messages.DeleteExpired(app.config.reaper.batchSize)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"worker": "reaper"})
+			return
+		}
+
+		byConversation := make(map[int][]int)
+		for _, message := range deleted {
+			byConversation[message.ConversationId] = append(byConversation[message.ConversationId], message.MessageId)
+		}
+		for conversationID, messageIDs := range byConversation {
+			app.events.Publish(events.Event{
+				Type:           events.TypeMessagesDeleted,
+				ConversationId: conversationID,
+				Data:           map[string]interface{}{"message_ids": messageIDs, "reason": "expired"},
+			})
+		}
+
+		// A short batch means we have caught up, the rest waits for the next tick.
+		if len(deleted) < app.config.reaper.batchSize {
+			return
+		}
+
+		select {
+		case <-app.done:
+			return
+		default:
+		}
+	}
+}
+
+// reapIdempotencyKeys deletes the idempotency keys of sent messages once their retention window
+// has passed, in batches.
+func (app *application) reapIdempotencyKeys() {
+	for {
+		deleted, err := app.models.Messages.DeleteExpiredIdempotencyKeys(app.config.reaper.batchSize)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"worker": "idempotency keys"})
+			return
+		}
+
+		// A short batch means we have caught up, the rest waits for the next tick.
+		if deleted < app.config.reaper.batchSize {
+			return
+		}
+
+		select {
+		case <-app.done:
+			return
+		default:
+		}
+	}
+}
+
+const (
+	// linkPreviewFailureTTL is how long a failed fetch is remembered before the page is tried
+	// again for another message.
+	linkPreviewFailureTTL = time.Hour
+	// linkPreviewAttempts is how often a preview is claimed before it is given up on, in case a
+	// worker keeps dying on it.
+	linkPreviewAttempts = 3
+)
+
+// generateLinkPreviews generates the previews of links in new and edited messages, in batches,
+// and announces each one to the conversation of its message. The links of a batch are fetched
+// concurrently, so a slow site holds the batch up for at most the fetch timeout.
+func (app *application) generateLinkPreviews() {
+	// A claim outlives the slowest fetch by far before another worker may take the link over.
+	stale := time.Minute + app.config.linkPreviews.timeout
+
+	for {
+		pending, err := app.models.LinkPreviews.Claim(app.config.linkPreviews.batchSize, stale, linkPreviewAttempts)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"worker": "link previews"})
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, p := range pending {
+			wg.Add(1)
+			go func(p *models.PendingLinkPreview) {
+				defer wg.Done()
+				app.generateLinkPreview(p)
+			}(p)
+		}
+		wg.Wait()
+
+		// A short batch means we have caught up, the rest waits for the next tick.
+		if len(pending) < app.config.linkPreviews.batchSize {
+			return
+		}
+
+		select {
+		case <-app.done:
+			return
+		default:
+		}
+	}
+}
+
+// generateLinkPreview generates the preview of one message, from the cache if the link was
+// fetched recently. Errors leave the preview pending, to be claimed again once the claim is stale.
+func (app *application) generateLinkPreview(p *models.PendingLinkPreview) {
+	preview, err := app.models.LinkPreviews.Cached(p.URL, app.config.linkPreviews.cacheTTL, linkPreviewFailureTTL)
+	if errors.Is(err, models.ErrRecordNotFound) {
+		preview, err = app.fetchLinkPreview(p.URL)
+	}
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"worker": "link previews", "url": p.URL})
+		return
+	}
+
+	status := models.LinkPreviewDone
+	if preview.Failed {
+		status = models.LinkPreviewFailed
+	}
+	updated, err := app.models.LinkPreviews.Complete(p.MessageId, p.URL, status)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"worker": "link previews", "url": p.URL})
+		return
+	}
+	if !updated || preview.Failed {
+		return
+	}
+
+	app.events.Publish(events.Event{
+		Type:           events.TypeLinkPreview,
+		ConversationId: p.ConversationId,
+		Data:           map[string]interface{}{"message_id": p.MessageId, "link_preview": preview},
+	})
+}
+
+// fetchLinkPreview fetches the page behind a link and caches its preview. Pages that can't be
+// previewed, whether they are unreachable, forbidden or have nothing to show, are cached as
+// failed, so that they aren't fetched again for every message linking them.
+func (app *application) fetchLinkPreview(url string) (*models.LinkPreview, error) {
+	preview := &models.LinkPreview{URL: url}
+
+	fetched, err := app.previews.Fetch(context.Background(), url)
+	if err != nil {
+		app.logger.PrintInfo("link preview failed", map[string]string{"url": url, "error": err.Error()})
+		preview.Failed = true
+	} else {
+		preview.Title = fetched.Title
+		preview.Description = fetched.Description
+		preview.ImageURL = fetched.ImageURL
+		preview.SiteName = fetched.SiteName
+	}
+
+	if err := app.models.LinkPreviews.Store(preview); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
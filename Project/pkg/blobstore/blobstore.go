@@ -0,0 +1,62 @@
+// Package blobstore stores opaque binary objects, such as uploaded media and attachments, under
+// string keys. The application only talks to the Store interface, so the storage backend can be
+// picked through configuration.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an object doesn't exist.
+	ErrNotFound = errors.New("blobstore: object not found")
+
+	// ErrInvalidKey is returned for keys that are empty, absolute or try to escape the store.
+	ErrInvalidKey = errors.New("blobstore: invalid key")
+)
+
+// Store is a place to put, fetch and remove objects.
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the object stored under key. The caller must close the returned body.
+	Get(ctx context.Context, key string) (*Object, error)
+
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an object read from a Store.
+type Object struct {
+	Body io.ReadCloser
+	Size int64
+	// ContentType is empty if the store doesn't know it.
+	ContentType string
+	ModTime     time.Time
+}
+
+// validKey reports whether key is a relative, slash separated path made of safe characters,
+// without empty, "." or ".." segments.
+func validKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, r := range segment {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the application.
+const (
+	TypeMessagesDeleted = "messages.deleted"
+	TypeMentionCreated  = "mention.created"
+	TypeMessagePinned   = "message.pinned"
+	TypeMessageUnpinned = "message.unpinned"
+	TypeDraftUpdated    = "draft.updated"
+	TypePollVoted       = "poll.voted"
+	TypeLinkPreview     = "message.link_preview"
+	TypeMessageReceipt  = "message.receipt"
+	TypeMembership      = "membership.changed"
+)
+
+// Event is a single notification about something that happened in a conversation.
+type Event struct {
+	Type           string      `json:"type"`
+	ConversationId int         `json:"conversation_id"`
+	Data           interface{} `json:"data,omitempty"`
+	Time           time.Time   `json:"time"`
+
+	// UserIds limits delivery to these members of the conversation. Events without it go to
+	// every member.
+	UserIds []int64 `json:"-"`
+}
+
+// For reports whether the event should be delivered to the given member of its conversation.
+func (e Event) For(userId int64) bool {
+	if e.UserIds == nil {
+		return true
+	}
+	for _, id := range e.UserIds {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events published on a Broker on its C channel until it is
+// unsubscribed.
+type Subscription struct {
+	C <-chan Event
+	c chan Event
+}
+
+// Broker is an in-process publish/subscribe hub. Publishing never blocks: if a subscriber isn't
+// keeping up, events for it are dropped rather than holding up the publisher.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker returns a new Broker with no subscribers.
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber whose channel can hold up to buffer undelivered events.
+func (b *Broker) Subscribe(buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+// Publish sends the event to every subscriber. The event time is set if it is empty.
+func (b *Broker) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscribers {
+		select {
+		case s.c <- e:
+		default:
+		}
+	}
+}
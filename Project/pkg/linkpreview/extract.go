@@ -0,0 +1,119 @@
+package linkpreview
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the extracted fields, pages can put anything in their meta tags.
+const (
+	maxTitle       = 300
+	maxDescription = 1000
+	maxSiteName    = 100
+	maxImageURL    = 2048
+)
+
+var (
+	// metaRX matches meta tags, attrRX the attributes inside them.
+	metaRX  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRX  = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	titleRX = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	spaceRX = regexp.MustCompile(`\s+`)
+
+	// urlRX matches http and https URLs in plain text.
+	urlRX = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `]+`)
+)
+
+// extract builds a preview from the OpenGraph tags of a page, falling back to Twitter cards and
+// then to the title and description every page may have. Relative image URLs are resolved
+// against base.
+func extract(page string, base *url.URL) *Preview {
+	meta := make(map[string]string)
+	for _, tag := range metaRX.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attrRX.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = strings.Trim(m[2], `"'`)
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		// The first occurrence wins, later ones are usually alternatives.
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = attrs["content"]
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := clean(meta[key]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	preview := &Preview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if m := titleRX.FindStringSubmatch(page); m != nil {
+			preview.Title = clean(m[1])
+		}
+	}
+
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image"); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+
+	preview.Title = truncate(preview.Title, maxTitle)
+	preview.Description = truncate(preview.Description, maxDescription)
+	preview.SiteName = truncate(preview.SiteName, maxSiteName)
+	if len(preview.ImageURL) > maxImageURL {
+		preview.ImageURL = ""
+	}
+	return preview
+}
+
+// clean unescapes HTML entities and collapses whitespace.
+func clean(s string) string {
+	return strings.TrimSpace(spaceRX.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+// truncate shortens s to at most n runes, cutting at a word boundary where it can.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n-1]
+	cut := string(runes)
+	if i := strings.LastIndexByte(cut, ' '); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "…"
+}
+
+// ExtractURLs returns the http and https URLs in text, in order of appearance. Punctuation ending a
+// sentence isn't taken as part of a URL.
+func ExtractURLs(text string) []string {
+	var urls []string
+	for _, match := range urlRX.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?")
+		// A closing parenthesis belongs to the URL only if it opened one too.
+		for strings.HasSuffix(match, ")") && strings.Count(match, "(") < strings.Count(match, ")") {
+			match = strings.TrimSuffix(match, ")")
+		}
+		if u, err := url.Parse(match); err == nil && u.Host != "" {
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,223 @@
+// Package linkpreview fetches web pages on behalf of users and extracts an OpenGraph preview from
+// them. Since the URLs come from messages, the fetcher treats every one of them as hostile: it
+// never connects to private or otherwise internal addresses, honours robots.txt, and limits how
+// long a fetch may take and how much it may read.
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbiddenAddress is returned when a URL resolves to an address that must not be fetched,
+	// such as a private network or the loopback interface.
+	ErrForbiddenAddress = errors.New("forbidden address")
+
+	// ErrDisallowed is returned when the site's robots.txt doesn't allow fetching the URL.
+	ErrDisallowed = errors.New("disallowed by robots.txt")
+
+	// ErrUnsupportedURL is returned for URLs that aren't absolute http or https URLs.
+	ErrUnsupportedURL = errors.New("unsupported URL")
+
+	// ErrNoPreview is returned when the page has nothing to build a preview from.
+	ErrNoPreview = errors.New("no preview")
+)
+
+// Preview is what a page says about itself.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Options configure a Fetcher.
+type Options struct {
+	// Timeout limits a whole fetch, including robots.txt, redirects and reading the body.
+	Timeout time.Duration
+	// MaxBytes is the most that is read of a page. Anything past it is ignored.
+	MaxBytes int64
+	// UserAgent is sent with every request, its first word is the product token looked for in
+	// robots.txt.
+	UserAgent string
+	// MaxRedirects is the number of redirects followed.
+	MaxRedirects int
+}
+
+// Fetcher fetches link previews. It is safe for concurrent use.
+type Fetcher struct {
+	opts   Options
+	client *http.Client
+
+	// denied reports whether an address must not be connected to. It is DeniedIP unless tests
+	// need to reach a local server.
+	denied func(net.IP) bool
+
+	mu     sync.Mutex
+	robots map[string]*robotsEntry
+}
+
+// New returns a Fetcher with the given options.
+func New(opts Options) *Fetcher {
+	f := &Fetcher{
+		opts:   opts,
+		denied: DeniedIP,
+		robots: make(map[string]*robotsEntry),
+	}
+
+	// The address is checked after DNS resolution, right before connecting, so a host name that
+	// resolves to an internal address is caught as well, whatever the name looked like.
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || f.denied(ip) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	f.client = &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			// Never go through a proxy from the environment, it would connect on our behalf
+			// without the address check.
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   opts.Timeout,
+			ResponseHeaderTimeout: opts.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", opts.MaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrUnsupportedURL
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Fetch downloads the page at rawURL and extracts its preview.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.opts.Timeout)
+	defer cancel()
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil, ErrUnsupportedURL
+	}
+
+	allowed, err := f.allowedByRobots(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrDisallowed
+	}
+
+	res, err := f.get(ctx, u.String(), "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, f.opts.MaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative image URLs are relative to wherever the redirects ended.
+	preview := extract(string(body), res.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// get sends a GET request for rawURL.
+func (f *Fetcher) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.opts.UserAgent)
+	req.Header.Set("Accept", accept)
+	return f.client.Do(req)
+}
+
+// deniedNets are the special purpose ranges not covered by the net.IP predicates in DeniedIP.
+var deniedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // "this" network
+		"100.64.0.0/10",   // carrier-grade NAT
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"240.0.0.0/4",     // reserved, including broadcast
+		"64:ff9b::/96",    // NAT64, which can reach IPv4 addresses
+		"64:ff9b:1::/48",  // local-use NAT64
+		"2001:db8::/32",   // documentation
+		"2002::/16",       // 6to4, which embeds IPv4 addresses
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// DeniedIP reports whether ip is anything but a public unicast address: loopback, private,
+// link-local, multicast, unspecified or reserved for special purposes.
+func DeniedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range deniedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// productToken returns the name the fetcher goes by in robots.txt.
+func (f *Fetcher) productToken() string {
+	token, _, _ := strings.Cut(f.opts.UserAgent, "/")
+	return strings.ToLower(strings.TrimSpace(token))
+}
@@ -0,0 +1,195 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeniedIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		denied bool
+	}{
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"2002:a00:1::", true},
+		{"2001:db8::1", true},
+		{"93.184.216.34", false},
+		{"8.8.8.8", false},
+		{"::ffff:8.8.8.8", false},
+		{"2606:4700:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test IP %q", tt.ip)
+		}
+		if got := DeniedIP(ip); got != tt.denied {
+			t.Errorf("DeniedIP(%s) = %v, want %v", tt.ip, got, tt.denied)
+		}
+	}
+}
+
+// newTestServer serves a small site on the loopback interface.
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /\n\nUser-agent: TestBot\nDisallow: /private\n")
+	})
+	page := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head>
+			<title>Fallback</title>
+			<meta property="og:title" content="Example &amp; Co">
+			<meta property="og:description" content="  A   page  ">
+			<meta property="og:site_name" content="Example">
+			<meta property="og:image" content="/img.png">
+			</head></html>`)
+	}
+	mux.HandleFunc("/page", page)
+	mux.HandleFunc("/private", page)
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/page", http.StatusFound)
+	})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/page", http.StatusFound)
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "<title>Not HTML</title>")
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, strings.Repeat(" ", 4096)+"<title>Too late</title>")
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestFetcher returns a fetcher that may connect to the loopback test server, every other
+// address is still checked.
+func newTestFetcher(maxRedirects int) *Fetcher {
+	f := New(Options{
+		Timeout:      5 * time.Second,
+		MaxBytes:     1024,
+		UserAgent:    "TestBot/1.0",
+		MaxRedirects: maxRedirects,
+	})
+	f.denied = func(ip net.IP) bool {
+		return !ip.IsLoopback() && DeniedIP(ip)
+	}
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer(t)
+	f := newTestFetcher(2)
+
+	preview, err := f.Fetch(context.Background(), server.URL+"/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Preview{
+		URL:         server.URL + "/redirect",
+		Title:       "Example & Co",
+		Description: "A page",
+		ImageURL:    server.URL + "/img.png",
+		SiteName:    "Example",
+	}
+	if *preview != want {
+		t.Errorf("Fetch() = %+v, want %+v", *preview, want)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name string
+		url  string
+		err  error
+	}{
+		{"disallowed by robots.txt", server.URL + "/private", ErrDisallowed},
+		{"redirect to an internal address", server.URL + "/internal", ErrForbiddenAddress},
+		{"redirect to another scheme", server.URL + "/ftp", ErrUnsupportedURL},
+		{"not html", server.URL + "/text", ErrNoPreview},
+		{"title past the size limit", server.URL + "/big", ErrNoPreview},
+		{"relative URL", "/page", ErrUnsupportedURL},
+		{"other scheme", "file:///etc/passwd", ErrUnsupportedURL},
+		{"credentials", strings.Replace(server.URL, "://", "://user:pass@", 1) + "/page", ErrUnsupportedURL},
+	}
+
+	f := newTestFetcher(2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.Fetch(context.Background(), tt.url)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Fetch(%q) error = %v, want %v", tt.url, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFetchRedirectLimit(t *testing.T) {
+	server := newTestServer(t)
+
+	if _, err := newTestFetcher(0).Fetch(context.Background(), server.URL+"/redirect"); err == nil {
+		t.Error("Fetch() followed a redirect with MaxRedirects 0")
+	}
+	if _, err := newTestFetcher(1).Fetch(context.Background(), server.URL+"/redirect"); err != nil {
+		t.Errorf("Fetch() with one redirect error = %v", err)
+	}
+	if _, err := newTestFetcher(5).Fetch(context.Background(), server.URL+"/loop"); err == nil {
+		t.Error("Fetch() followed a redirect loop")
+	}
+}
+
+// The default fetcher refuses the loopback server at connection time, already for robots.txt.
+func TestFetchDeniesLoopback(t *testing.T) {
+	server := newTestServer(t)
+	f := New(Options{Timeout: 5 * time.Second, MaxBytes: 1024, UserAgent: "TestBot/1.0", MaxRedirects: 2})
+
+	_, err := f.Fetch(context.Background(), server.URL+"/page")
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch() error = %v, want %v", err, ErrForbiddenAddress)
+	}
+
+	// A host name is resolved first, the check applies to the address it resolves to.
+	_, err = f.Fetch(context.Background(), strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/page")
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch() via localhost error = %v, want %v", err, ErrForbiddenAddress)
+	}
+}
@@ -0,0 +1,171 @@
+package linkpreview
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// robotsTTL is how long a site's robots.txt is remembered.
+	robotsTTL = time.Hour
+	// maxRobotsBytes is the most that is read of a robots.txt, as recommended by RFC 9309.
+	maxRobotsBytes = 500 << 10
+	// maxRobotsHosts bounds the number of remembered sites.
+	maxRobotsHosts = 1000
+)
+
+// robotsEntry is the parsed robots.txt of a site.
+type robotsEntry struct {
+	rules   []robotsRule
+	expires time.Time
+}
+
+// robotsRule allows or disallows paths starting with a pattern, which may contain "*" wildcards
+// and end with "$" to match the whole path.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// allowedByRobots reports whether the site's robots.txt lets us fetch u. A missing robots.txt
+// allows everything, one that can't be fetched because of a server error allows nothing.
+func (f *Fetcher) allowedByRobots(ctx context.Context, u *url.URL) (bool, error) {
+	site := u.Scheme + "://" + u.Host
+
+	f.mu.Lock()
+	entry, ok := f.robots[site]
+	f.mu.Unlock()
+
+	if !ok || time.Now().After(entry.expires) {
+		rules, err := f.fetchRobots(ctx, site)
+		if err != nil {
+			return false, err
+		}
+		entry = &robotsEntry{rules: rules, expires: time.Now().Add(robotsTTL)}
+
+		f.mu.Lock()
+		if len(f.robots) >= maxRobotsHosts {
+			f.robots = make(map[string]*robotsEntry)
+		}
+		f.robots[site] = entry
+		f.mu.Unlock()
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return allowed(entry.rules, path), nil
+}
+
+// fetchRobots downloads the robots.txt of a site and returns the rules that apply to us.
+func (f *Fetcher) fetchRobots(ctx context.Context, site string) ([]robotsRule, error) {
+	res, err := f.get(ctx, site+"/robots.txt", "text/plain")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		return []robotsRule{{allow: false, pattern: "/"}}, nil
+	case res.StatusCode >= 400:
+		return nil, nil
+	case res.StatusCode != 200:
+		return nil, errors.New("unexpected status " + res.Status + " for robots.txt")
+	}
+
+	return parseRobots(io.LimitReader(res.Body, maxRobotsBytes), f.productToken())
+}
+
+// parseRobots returns the rules of the group for the product token, or of the "*" group if there
+// is none.
+func parseRobots(r io.Reader, token string) ([]robotsRule, error) {
+	var (
+		ours, wildcard []robotsRule
+		foundOurs      bool
+		agents         []string
+		inRules        bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A user-agent line after rules starts a new group.
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agent := strings.ToLower(value)
+			agents = append(agents, agent)
+			if token != "" && agent == token {
+				foundOurs = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			for _, agent := range agents {
+				switch {
+				case token != "" && agent == token:
+					ours = append(ours, rule)
+				case agent == "*":
+					wildcard = append(wildcard, rule)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return nil, err
+	}
+
+	if foundOurs {
+		return ours, nil
+	}
+	return wildcard, nil
+}
+
+// allowed applies the most specific matching rule to path, allow winning ties. Paths no rule
+// matches are allowed.
+func allowed(rules []robotsRule, path string) bool {
+	best, result := -1, true
+	for _, rule := range rules {
+		if !matchRobots(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > best || len(rule.pattern) == best && rule.allow {
+			best, result = len(rule.pattern), rule.allow
+		}
+	}
+	return result
+}
+
+// matchRobots reports whether a robots.txt pattern matches the start of path.
+func matchRobots(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	rx, err := regexp.Compile(expr)
+	return err == nil && rx.MatchString(path)
+}
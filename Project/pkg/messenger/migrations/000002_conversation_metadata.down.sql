@@ -0,0 +1,6 @@
+ALTER TABLE user_conversations
+    DROP COLUMN IF EXISTS title,
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS avatar,
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS version;
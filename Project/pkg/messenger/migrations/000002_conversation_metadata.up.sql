@@ -0,0 +1,6 @@
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS title       text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS description text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar      text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS updated_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS version     integer                     NOT NULL DEFAULT 1;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS conversation_members
+(
+    conversation_id int                         NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    user_id         bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    role            text                        NOT NULL DEFAULT 'member',
+    joined_at       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (conversation_id, user_id),
+    CONSTRAINT conversation_members_role_check CHECK (role IN ('owner', 'admin', 'member'))
+);
+
+CREATE INDEX IF NOT EXISTS conversation_members_user_id_idx ON conversation_members (user_id);
+
+-- Every conversation has exactly one owner.
+CREATE UNIQUE INDEX IF NOT EXISTS conversation_members_owner_idx ON conversation_members (conversation_id)
+    WHERE role = 'owner';
+
+-- Existing conversations are owned by the user who created them, the friend becomes a member.
+INSERT INTO conversation_members (conversation_id, user_id, role)
+SELECT conversation_id, user_id, 'owner'
+FROM user_conversations
+WHERE user_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO conversation_members (conversation_id, user_id, role)
+SELECT conversation_id, friend_id, 'member'
+FROM user_conversations
+WHERE friend_id IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS invite_requests;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS channel_members;
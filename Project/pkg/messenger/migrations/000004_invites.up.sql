@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS channel_members
+(
+    channel_id int                         NOT NULL REFERENCES channels (channel_id) ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    joined_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (channel_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS channel_members_user_id_idx ON channel_members (user_id);
+
+-- The creator of a channel is its first member.
+INSERT INTO channel_members (channel_id, user_id)
+SELECT channel_id, user_id
+FROM channels
+WHERE user_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS invites
+(
+    id                bigserial PRIMARY KEY,
+    hash              bytea UNIQUE                NOT NULL,
+    conversation_id   int REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    channel_id        int REFERENCES channels (channel_id) ON DELETE CASCADE,
+    created_by        bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at        timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry            timestamp(0) with time zone,
+    max_uses          integer,
+    uses              integer                     NOT NULL DEFAULT 0,
+    requires_approval bool                        NOT NULL DEFAULT false,
+    revoked_at        timestamp(0) with time zone,
+    CONSTRAINT invites_target_check CHECK ((conversation_id IS NULL) <> (channel_id IS NULL)),
+    CONSTRAINT invites_max_uses_check CHECK (max_uses IS NULL OR max_uses > 0)
+);
+
+CREATE TABLE IF NOT EXISTS invite_requests
+(
+    invite_id  bigint                      NOT NULL REFERENCES invites ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    status     text                        NOT NULL DEFAULT 'pending',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    decided_at timestamp(0) with time zone,
+    PRIMARY KEY (invite_id, user_id),
+    CONSTRAINT invite_requests_status_check CHECK (status IN ('pending', 'approved', 'rejected'))
+);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS messages_expires_at_idx;
+
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS messages_type_check,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS type;
+
+ALTER TABLE user_conversations
+    DROP CONSTRAINT IF EXISTS user_conversations_message_ttl_check,
+    DROP COLUMN IF EXISTS message_ttl;
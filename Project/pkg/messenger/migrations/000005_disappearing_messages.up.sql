@@ -0,0 +1,10 @@
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS message_ttl integer NOT NULL DEFAULT 0,
+    ADD CONSTRAINT user_conversations_message_ttl_check CHECK (message_ttl IN (0, 3600, 86400, 604800));
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS type       text NOT NULL DEFAULT 'text',
+    ADD COLUMN IF NOT EXISTS expires_at timestamp(0) with time zone,
+    ADD CONSTRAINT messages_type_check CHECK (type IN ('text', 'system'));
+
+CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON messages (expires_at) WHERE expires_at IS NOT NULL;
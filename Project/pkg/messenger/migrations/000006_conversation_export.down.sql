@@ -0,0 +1,7 @@
+DELETE FROM permissions
+WHERE code = 'conversation:export';
+
+DROP INDEX IF EXISTS messages_conversation_id_timestamp_idx;
+
+ALTER TABLE messages
+    ALTER COLUMN timestamp TYPE timestamp(0) USING timestamp AT TIME ZONE 'UTC';
@@ -0,0 +1,9 @@
+-- Message timestamps are stored in UTC, make that explicit so they are returned with an offset.
+ALTER TABLE messages
+    ALTER COLUMN timestamp TYPE timestamp(0) with time zone USING timestamp AT TIME ZONE 'UTC';
+
+CREATE INDEX IF NOT EXISTS messages_conversation_id_timestamp_idx ON messages (conversation_id, timestamp, message_id);
+
+-- Lets compliance staff export any conversation without being a member of it.
+INSERT INTO permissions (code)
+VALUES ('conversation:export');
@@ -0,0 +1,6 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS reply_to_message_id int REFERENCES messages (message_id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS deleted_at          timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS messages_reply_to_message_id_idx ON messages (reply_to_message_id)
+    WHERE reply_to_message_id IS NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS message_reactions
+(
+    message_id int                         NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    emoji      text                        NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, emoji, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_reactions_user_id_idx ON message_reactions (user_id);
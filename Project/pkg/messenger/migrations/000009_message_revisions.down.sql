@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS message_revisions;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS revision_count,
+    DROP COLUMN IF EXISTS edited_at;
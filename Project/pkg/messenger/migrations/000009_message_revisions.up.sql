@@ -0,0 +1,15 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS edited_at      timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS revision_count integer NOT NULL DEFAULT 0;
+
+-- Each row keeps a version of a message's content that was replaced by an edit. Revision 1 is the
+-- content the message was originally sent with.
+CREATE TABLE IF NOT EXISTS message_revisions
+(
+    message_id  int                         NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    revision    integer                     NOT NULL,
+    content     text                        NOT NULL,
+    written_at  timestamp(0) with time zone NOT NULL,
+    replaced_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, revision)
+);
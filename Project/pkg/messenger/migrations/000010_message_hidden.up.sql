@@ -0,0 +1,10 @@
+-- Messages a user deleted for themselves only.
+CREATE TABLE IF NOT EXISTS message_hidden
+(
+    message_id int                         NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    hidden_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_hidden_message_id_idx ON message_hidden (message_id);
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS attachments
+(
+    id            bigserial PRIMARY KEY,
+    owner_id      bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    storage_key   text UNIQUE                 NOT NULL,
+    filename      text                        NOT NULL,
+    content_type  text                        NOT NULL,
+    size          bigint                      NOT NULL,
+    width         integer,
+    height        integer,
+    thumbnail_key text,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_owner_id_idx ON attachments (owner_id);
+
+-- Attachments are linked to messages rather than owned by them, so that the same file can be
+-- shared by several messages.
+CREATE TABLE IF NOT EXISTS message_attachments
+(
+    message_id    int     NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    attachment_id bigint  NOT NULL REFERENCES attachments ON DELETE CASCADE,
+    position      integer NOT NULL,
+    PRIMARY KEY (message_id, attachment_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_attachments_attachment_id_idx ON message_attachments (attachment_id);
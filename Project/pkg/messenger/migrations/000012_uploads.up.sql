@@ -0,0 +1,18 @@
+-- Resumable uploads in progress. Every chunk received is stored as a separate blob, keyed by the
+-- offset it starts at, and the chunks are joined into an attachment once the upload is complete.
+CREATE TABLE IF NOT EXISTS uploads
+(
+    id            text PRIMARY KEY,
+    owner_id      bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    length        bigint                      NOT NULL,
+    received      bigint                      NOT NULL DEFAULT 0,
+    parts         bigint[]                    NOT NULL DEFAULT '{}',
+    filename      text                        NOT NULL DEFAULT '',
+    attachment_id bigint REFERENCES attachments ON DELETE SET NULL,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at    timestamp(0) with time zone NOT NULL,
+    CONSTRAINT uploads_received_check CHECK (received >= 0 AND received <= length)
+);
+
+CREATE INDEX IF NOT EXISTS uploads_owner_id_idx ON uploads (owner_id);
+CREATE INDEX IF NOT EXISTS uploads_expires_at_idx ON uploads (expires_at);
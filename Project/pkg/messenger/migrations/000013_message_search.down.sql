@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messages_search_vector_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS search_language;
@@ -0,0 +1,7 @@
+-- The text search configuration is stored per message, so that changing the configured language
+-- only affects new messages and the generated vectors stay consistent with how they were built.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS search_language regconfig NOT NULL DEFAULT 'english',
+    ADD COLUMN IF NOT EXISTS search_vector   tsvector GENERATED ALWAYS AS (to_tsvector(search_language, content)) STORED;
+
+CREATE INDEX IF NOT EXISTS messages_search_vector_idx ON messages USING GIN (search_vector);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS mentions;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS mentions;
+
+ALTER TABLE user_conversations
+    DROP CONSTRAINT IF EXISTS user_conversations_mention_policy_check,
+    DROP COLUMN IF EXISTS mention_policy;
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_handle_check,
+    DROP COLUMN IF EXISTS handle;
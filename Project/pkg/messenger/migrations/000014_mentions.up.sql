@@ -0,0 +1,29 @@
+-- Handles let users be mentioned as @handle. They are optional and unique regardless of case.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS handle citext UNIQUE,
+    ADD CONSTRAINT users_handle_check CHECK (handle ~ '^[A-Za-z][A-Za-z0-9_]{2,31}$');
+
+-- mention_policy decides what happens to mentions of users outside the conversation: they are
+-- either left as plain text or the message is rejected.
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS mention_policy text NOT NULL DEFAULT 'ignore',
+    ADD CONSTRAINT user_conversations_mention_policy_check CHECK (mention_policy IN ('ignore', 'reject'));
+
+-- The mention entities of a message, with their offsets into the content, as sent to clients.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS mentions jsonb NOT NULL DEFAULT '[]';
+
+-- One row per mentioned user and message, feeding the user's mentions list. The sender is never
+-- notified of their own mentions.
+CREATE TABLE IF NOT EXISTS mentions
+(
+    message_id      int                         NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id         bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    conversation_id int                         NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    read_at         timestamp(0) with time zone,
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS mentions_message_id_idx ON mentions (message_id);
+CREATE INDEX IF NOT EXISTS mentions_unread_idx ON mentions (user_id) WHERE read_at IS NULL;
@@ -0,0 +1,22 @@
+-- Messages written now and sent later. The dispatcher turns due rows into regular messages and
+-- records the result, so a row is never sent twice, even with several servers running.
+CREATE TABLE IF NOT EXISTS scheduled_messages
+(
+    id                  bigserial PRIMARY KEY,
+    conversation_id     int                         NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    sender_id           bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    content             text                        NOT NULL,
+    reply_to_message_id int REFERENCES messages (message_id) ON DELETE SET NULL,
+    attachment_ids      bigint[]                    NOT NULL DEFAULT '{}',
+    send_at             timestamp(0) with time zone NOT NULL,
+    status              text                        NOT NULL DEFAULT 'scheduled',
+    message_id          int REFERENCES messages (message_id) ON DELETE SET NULL,
+    error               text                        NOT NULL DEFAULT '',
+    created_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version             integer                     NOT NULL DEFAULT 1,
+    CONSTRAINT scheduled_messages_status_check CHECK (status IN ('scheduled', 'sent', 'failed', 'cancelled'))
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (send_at) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS scheduled_messages_sender_idx ON scheduled_messages (conversation_id, sender_id);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS messages_forwarded_from_message_id_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS forwarded_from_timestamp,
+    DROP COLUMN IF EXISTS forwarded_from_message_id,
+    DROP COLUMN IF EXISTS forwarded_from_conversation_id,
+    DROP COLUMN IF EXISTS forwarded_from_sender_id,
+    DROP COLUMN IF EXISTS forwarded;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS allow_forward_attribution;
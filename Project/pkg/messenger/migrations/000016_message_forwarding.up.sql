@@ -0,0 +1,16 @@
+-- Users can stop forwarded copies of their messages from naming them as the original sender.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS allow_forward_attribution bool NOT NULL DEFAULT TRUE;
+
+-- Forwarded copies remember where they came from. The origin columns stay empty when the original
+-- sender doesn't allow attribution, the copy is still marked as forwarded. Message timestamps are
+-- stored in UTC without a zone, the original's is converted when it is copied.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS forwarded                      bool NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS forwarded_from_sender_id       bigint REFERENCES users ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS forwarded_from_conversation_id int REFERENCES user_conversations (conversation_id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS forwarded_from_message_id      int REFERENCES messages (message_id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS forwarded_from_timestamp       timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS messages_forwarded_from_message_id_idx ON messages (forwarded_from_message_id)
+    WHERE forwarded_from_message_id IS NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS pinned_messages
+(
+    conversation_id int                      NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    message_id      int                      NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    pinned_by       bigint REFERENCES users ON DELETE SET NULL,
+    pinned_at       timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (conversation_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS pinned_messages_message_id_idx ON pinned_messages (message_id);
@@ -0,0 +1,27 @@
+-- Messages users saved for themselves. The bookmark keeps a copy of the message, which follows
+-- edits and stays behind when the message is deleted, so saved content is never lost. The text
+-- search configuration is taken over from the message.
+CREATE TABLE IF NOT EXISTS bookmarks
+(
+    id              bigserial PRIMARY KEY,
+    user_id         bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    message_id      int REFERENCES messages (message_id) ON DELETE SET NULL,
+    conversation_id int REFERENCES user_conversations (conversation_id) ON DELETE SET NULL,
+    sender_id       bigint REFERENCES users ON DELETE SET NULL,
+    content         text                        NOT NULL,
+    sent_at         timestamp(0) with time zone NOT NULL,
+    note            text                        NOT NULL DEFAULT '',
+    tags            text[]                      NOT NULL DEFAULT '{}',
+    search_language regconfig                   NOT NULL DEFAULT 'english',
+    search_vector   tsvector GENERATED ALWAYS AS (to_tsvector(search_language, content) ||
+                                                  to_tsvector(search_language, note)) STORED,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version         integer                     NOT NULL DEFAULT 1,
+    CONSTRAINT bookmarks_user_message_key UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS bookmarks_user_created_at_idx ON bookmarks (user_id, created_at);
+CREATE INDEX IF NOT EXISTS bookmarks_message_id_idx ON bookmarks (message_id);
+CREATE INDEX IF NOT EXISTS bookmarks_tags_idx ON bookmarks USING GIN (tags);
+CREATE INDEX IF NOT EXISTS bookmarks_search_vector_idx ON bookmarks USING GIN (search_vector);
@@ -0,0 +1,12 @@
+-- The message a user is writing in a conversation, kept on the server so that it follows them
+-- across devices. Sending a message to the conversation clears it.
+CREATE TABLE IF NOT EXISTS drafts
+(
+    user_id             bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    conversation_id     int                         NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    content             text                        NOT NULL DEFAULT '',
+    reply_to_message_id int REFERENCES messages (message_id) ON DELETE SET NULL,
+    updated_at          timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version             integer                     NOT NULL DEFAULT 1,
+    PRIMARY KEY (user_id, conversation_id)
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS poll_votes;
+
+DELETE FROM messages WHERE type = 'poll';
+
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS messages_type_check,
+    ADD CONSTRAINT messages_type_check CHECK (type IN ('text', 'system')),
+    DROP COLUMN IF EXISTS payload;
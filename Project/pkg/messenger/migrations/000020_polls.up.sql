@@ -0,0 +1,16 @@
+-- payload holds the typed body of messages that are more than text, e.g. the question, options
+-- and settings of a poll. The content of a poll is its question, so it can still be searched.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS payload jsonb,
+    DROP CONSTRAINT IF EXISTS messages_type_check,
+    ADD CONSTRAINT messages_type_check CHECK (type IN ('text', 'system', 'poll'));
+
+-- One row per option a user picked. Changing a vote replaces all of the user's rows.
+CREATE TABLE IF NOT EXISTS poll_votes
+(
+    message_id int                         NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    option_id  int                         NOT NULL,
+    voted_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, option_id)
+);
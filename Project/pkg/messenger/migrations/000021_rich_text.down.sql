@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS rendered_html,
+    DROP COLUMN IF EXISTS entities;
@@ -0,0 +1,5 @@
+-- Formatted messages store their plain text in content and the formatting as entities over it.
+-- rendered_html is the sanitized HTML rendering, only set for messages that have formatting.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS entities      jsonb NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS rendered_html text;
@@ -0,0 +1,26 @@
+-- Previews are cached by URL, so a link shared in many messages is fetched once. Failed fetches
+-- are cached too, for a shorter time, so that a broken site isn't hit for every message.
+CREATE TABLE IF NOT EXISTS link_previews
+(
+    url         text                     PRIMARY KEY,
+    title       text                     NOT NULL DEFAULT '',
+    description text                     NOT NULL DEFAULT '',
+    image_url   text                     NOT NULL DEFAULT '',
+    site_name   text                     NOT NULL DEFAULT '',
+    failed      boolean                  NOT NULL DEFAULT false,
+    fetched_at  timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+-- The link of a message to preview, and where the worker generating it is. A claimed row whose
+-- worker didn't finish is picked up again once the claim is stale, up to a few attempts.
+CREATE TABLE IF NOT EXISTS message_link_previews
+(
+    message_id int                      PRIMARY KEY REFERENCES messages (message_id) ON DELETE CASCADE,
+    url        text                     NOT NULL,
+    status     text                     NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'failed')),
+    attempts   int                      NOT NULL DEFAULT 0,
+    claimed_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS message_link_previews_pending_idx ON message_link_previews (created_at) WHERE status = 'pending';
@@ -0,0 +1,15 @@
+-- Keys clients send with a new message so that retrying the request doesn't send it twice. A key
+-- is unique per sender and conversation for as long as it is kept, the message it sent is
+-- returned for every retry. message_id is only NULL inside the transaction that sends it.
+CREATE TABLE IF NOT EXISTS message_idempotency_keys
+(
+    sender_id       bigint                   NOT NULL REFERENCES users ON DELETE CASCADE,
+    conversation_id int                      NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    key             text                     NOT NULL,
+    message_id      int REFERENCES messages (message_id) ON DELETE CASCADE,
+    created_at      timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (sender_id, conversation_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS message_idempotency_keys_created_at_idx ON message_idempotency_keys (created_at);
+CREATE INDEX IF NOT EXISTS message_idempotency_keys_message_id_idx ON message_idempotency_keys (message_id);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS conversation_changes;
+
+DROP INDEX IF EXISTS messages_conversation_id_seq_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS seq;
+
+ALTER TABLE user_conversations
+    DROP COLUMN IF EXISTS last_change_seq,
+    DROP COLUMN IF EXISTS last_message_seq;
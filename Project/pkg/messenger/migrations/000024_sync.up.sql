@@ -0,0 +1,50 @@
+-- Every message gets a sequence number within its conversation, without gaps, and every change to
+-- the messages of a conversation gets a change sequence number. Both counters live on the
+-- conversation, taking the next number locks its row until the transaction ends, so numbers are
+-- handed out in commit order and a rolled back transaction leaves no gap.
+ALTER TABLE user_conversations
+    ADD COLUMN IF NOT EXISTS last_message_seq bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_change_seq  bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS seq bigint;
+
+UPDATE messages m
+SET seq = numbered.seq
+FROM (SELECT message_id, row_number() OVER (PARTITION BY conversation_id ORDER BY message_id) AS seq
+      FROM messages) numbered
+WHERE numbered.message_id = m.message_id;
+
+ALTER TABLE messages
+    ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_conversation_id_seq_idx ON messages (conversation_id, seq);
+
+-- The change log clients sync from. message_id has no foreign key, the log outlives messages that
+-- are deleted for good so that clients still learn about the deletion.
+CREATE TABLE IF NOT EXISTS conversation_changes
+(
+    conversation_id int                      NOT NULL REFERENCES user_conversations (conversation_id) ON DELETE CASCADE,
+    change_seq      bigint                   NOT NULL,
+    message_id      int                      NOT NULL,
+    kind            text                     NOT NULL CHECK (kind IN ('created', 'edited', 'deleted')),
+    changed_at      timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (conversation_id, change_seq)
+);
+
+-- Existing messages count as created in the order they were sent, deleted ones as deleted after.
+INSERT INTO conversation_changes (conversation_id, change_seq, message_id, kind)
+SELECT conversation_id, seq, message_id, 'created'
+FROM messages;
+
+INSERT INTO conversation_changes (conversation_id, change_seq, message_id, kind)
+SELECT m.conversation_id, c.last_seq + row_number() OVER (PARTITION BY m.conversation_id ORDER BY m.message_id),
+       m.message_id, 'deleted'
+FROM messages m
+INNER JOIN (SELECT conversation_id, max(seq) AS last_seq FROM messages GROUP BY conversation_id) c
+    ON c.conversation_id = m.conversation_id
+WHERE m.deleted_at IS NOT NULL;
+
+UPDATE user_conversations uc
+SET last_message_seq = COALESCE((SELECT max(seq) FROM messages m WHERE m.conversation_id = uc.conversation_id), 0),
+    last_change_seq  = COALESCE((SELECT max(change_seq) FROM conversation_changes c WHERE c.conversation_id = uc.conversation_id), 0);
@@ -0,0 +1,13 @@
+-- Delivery and read receipts, one row per recipient once one of their devices acknowledged the
+-- message. A recipient without a row has only been sent the message. Reading implies delivery, so
+-- delivered_at is always set.
+CREATE TABLE IF NOT EXISTS message_receipts
+(
+    message_id   int                      NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id      bigint                   NOT NULL REFERENCES users ON DELETE CASCADE,
+    delivered_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    read_at      timestamp with time zone,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_receipts_user_id_idx ON message_receipts (user_id);
@@ -0,0 +1,10 @@
+-- The keys of unfinished uploads can't be turned back into offsets, so those uploads start over.
+ALTER TABLE uploads
+    ADD COLUMN IF NOT EXISTS parts bigint[] NOT NULL DEFAULT '{}';
+
+UPDATE uploads
+SET received = 0
+WHERE attachment_id IS NULL;
+
+ALTER TABLE uploads
+    DROP COLUMN IF EXISTS part_keys;
@@ -0,0 +1,14 @@
+-- Chunks used to be stored under a key made from their offset alone, so two requests sending the
+-- same chunk at once wrote to the same blob and the one that lost the race deleted the other's
+-- chunk while cleaning up. Every attempt now gets its own key, and the keys of the recorded
+-- chunks are kept in order instead of their offsets.
+ALTER TABLE uploads
+    ADD COLUMN IF NOT EXISTS part_keys text[] NOT NULL DEFAULT '{}';
+
+UPDATE uploads u
+SET part_keys = ARRAY(SELECT format('uploads/%s/%s', u.id, lpad(p.part_offset::text, 15, '0'))
+                      FROM unnest(u.parts) WITH ORDINALITY AS p (part_offset, position)
+                      ORDER BY p.position);
+
+ALTER TABLE uploads
+    DROP COLUMN IF EXISTS parts;
@@ -0,0 +1,4 @@
+DELETE FROM conversation_changes WHERE user_id IS NOT NULL;
+
+ALTER TABLE conversation_changes
+    DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,4 @@
+-- Some changes only concern one member, such as a message they deleted for themselves. They are
+-- logged with that member's ID and left out when anybody else syncs.
+ALTER TABLE conversation_changes
+    ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// MaxMessageAttachments is the number of attachments a single message can carry.
+const MaxMessageAttachments = 10
+
+var (
+	// ErrQuotaExceeded is returned when storing an attachment would take its owner over their
+	// storage quota.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+	// ErrInvalidAttachment is returned when a message references an attachment that doesn't exist
+	// or doesn't belong to the sender.
+	ErrInvalidAttachment = errors.New("invalid attachment")
+)
+
+// Attachment is an uploaded file. The file itself lives in the blob store under StorageKey.
+type Attachment struct {
+	ID           int64     `json:"id"`
+	OwnerId      int64     `json:"owner_id"`
+	StorageKey   string    `json:"-"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        *int      `json:"width,omitempty"`
+	Height       *int      `json:"height,omitempty"`
+	ThumbnailKey *string   `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// URL and ThumbnailURL point at the download endpoints, which check that the caller may see
+	// the attachment.
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// attachmentColumns lists the columns scanned by (*Attachment).scan, for queries where the
+// attachments table is aliased as "a".
+const attachmentColumns = `a.id, a.owner_id, a.storage_key, a.filename, a.content_type, a.size, a.width, a.height,
+	a.thumbnail_key, a.created_at`
+
+func (a *Attachment) scanDest() []interface{} {
+	return []interface{}{&a.ID, &a.OwnerId, &a.StorageKey, &a.Filename, &a.ContentType, &a.Size, &a.Width,
+		&a.Height, &a.ThumbnailKey, &a.CreatedAt}
+}
+
+// setURLs fills in the download URLs from the attachment ID.
+func (a *Attachment) setURLs() {
+	a.URL = fmt.Sprintf("/api/v1/attachments/%d", a.ID)
+	if a.ThumbnailKey != nil {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+}
+
+type AttachmentsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert records an uploaded attachment. The owner's row is locked while their usage is checked,
+// so concurrent uploads can't together exceed the quota. As in UploadsModel.New, the usage
+// includes the space reserved by unfinished resumable uploads, except for uploadId, the upload the
+// attachment is made from, if any. ErrQuotaExceeded is returned if the attachment doesn't fit in
+// the remaining quota.
+func (m AttachmentsModel) Insert(attachment *Attachment, quota int64, uploadId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT SUM(size) FROM attachments WHERE owner_id = u.id), 0) +
+			COALESCE((SELECT SUM(length) FROM uploads WHERE owner_id = u.id AND attachment_id IS NULL AND id <> $2), 0)
+		FROM users u
+		WHERE u.id = $1
+		FOR UPDATE OF u;`, attachment.OwnerId, uploadId).Scan(&used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if used+attachment.Size > quota {
+		return ErrQuotaExceeded
+	}
+
+	query := `
+		INSERT INTO attachments (owner_id, storage_key, filename, content_type, size, width, height, thumbnail_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at;
+		`
+	args := []interface{}{attachment.OwnerId, attachment.StorageKey, attachment.Filename, attachment.ContentType,
+		attachment.Size, attachment.Width, attachment.Height, attachment.ThumbnailKey}
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&attachment.ID, &attachment.CreatedAt); err != nil {
+		return err
+	}
+	attachment.setURLs()
+
+	return tx.Commit()
+}
+
+// GetForUser returns an attachment if the user may download it: either they uploaded it, or it is
+// attached to a message they can see in one of their conversations.
+func (m AttachmentsModel) GetForUser(id, userId int64) (*Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments a
+		WHERE a.id = $1 AND (a.owner_id = $2 OR EXISTS (
+			SELECT 1
+			FROM message_attachments ma
+			INNER JOIN messages m ON m.message_id = ma.message_id
+			INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+			WHERE ma.attachment_id = a.id AND cm.user_id = $2 AND ` + notExpired + ` AND ` + notHidden + `
+		));
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attachment Attachment
+	err := m.DB.QueryRowContext(ctx, query, id, userId).Scan(attachment.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	attachment.setURLs()
+	return &attachment, nil
+}
+
+// ForMessages returns the attachments of a set of messages in a single query, keyed by message
+// ID and in the order they were attached.
+func (m AttachmentsModel) ForMessages(messageIds []string) (map[string][]*Attachment, error) {
+	attachments := make(map[string][]*Attachment)
+	if len(messageIds) == 0 {
+		return attachments, nil
+	}
+
+	query := `
+		SELECT ma.message_id, ` + attachmentColumns + `
+		FROM message_attachments ma
+		INNER JOIN attachments a ON a.id = ma.attachment_id
+		WHERE ma.message_id = ANY($1::int[])
+		ORDER BY ma.message_id, ma.position;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(messageIds))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var messageId string
+		var attachment Attachment
+		if err := rows.Scan(append([]interface{}{&messageId}, attachment.scanDest()...)...); err != nil {
+			return nil, err
+		}
+		attachment.setURLs()
+		attachments[messageId] = append(attachments[messageId], &attachment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// attachToMessage links the sender's attachments to a message, in the given order, as part of
+// the transaction that inserts the message. ErrInvalidAttachment is returned if any of them
+// doesn't exist or wasn't uploaded by the sender.
+func attachToMessage(ctx context.Context, tx *sql.Tx, messageId string, senderId int, attachmentIds []int64) error {
+	if len(attachmentIds) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO message_attachments (message_id, attachment_id, position)
+		SELECT $1, a.id, ids.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ids (id, position)
+		INNER JOIN attachments a ON a.id = ids.id
+		WHERE a.owner_id = $3
+		ON CONFLICT DO NOTHING;
+		`
+	result, err := tx.ExecContext(ctx, query, messageId, pq.Array(attachmentIds), senderId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != int64(len(attachmentIds)) {
+		return ErrInvalidAttachment
+	}
+	return nil
+}
+
+// ValidateAttachmentIDs checks the attachments referenced by a new message.
+func ValidateAttachmentIDs(v *validator.Validator, ids []int64) {
+	v.Check(len(ids) <= MaxMessageAttachments, "attachment_ids",
+		fmt.Sprintf("must not contain more than %d attachments", MaxMessageAttachments))
+	v.Check(validator.Unique(ids), "attachment_ids", "must not contain duplicate values")
+	for _, id := range ids {
+		if id <= 0 {
+			v.AddError("attachment_ids", "must only contain valid attachment IDs")
+			break
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Limits on the note and tags of a bookmark.
+const (
+	MaxBookmarkNote = 1000
+	MaxBookmarkTags = 10
+)
+
+var (
+	// ErrDuplicateBookmark is returned when a user bookmarks a message they already bookmarked.
+	ErrDuplicateBookmark = errors.New("duplicate bookmark")
+
+	// BookmarkTagRX matches a normalized bookmark tag.
+	BookmarkTagRX = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+)
+
+// Bookmark is a message a user saved for themselves. Content and SentAt are a copy of the message
+// that is kept up to date with edits. Once the message is deleted, MessageDeleted is set and the
+// copy is all that is left.
+type Bookmark struct {
+	Id             int64     `json:"id"`
+	MessageId      *int      `json:"message_id"`
+	ConversationId *int      `json:"conversation_id"`
+	SenderId       *int64    `json:"sender_id"`
+	Content        string    `json:"content"`
+	SentAt         time.Time `json:"sent_at"`
+	MessageDeleted bool      `json:"message_deleted"`
+	Note           string    `json:"note"`
+	Tags           []string  `json:"tags"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"version"`
+}
+
+// bookmarkColumns lists the columns of the bookmark "b" scanned by (*Bookmark).scanDest.
+const bookmarkColumns = `b.id, b.message_id, b.conversation_id, b.sender_id, b.content, b.sent_at,
+	NOT EXISTS (SELECT 1 FROM messages m WHERE m.message_id = b.message_id AND m.deleted_at IS NULL AND ` + notExpired + `),
+	b.note, b.tags, b.created_at, b.updated_at, b.version`
+
+// scanDest returns the scan destinations matching bookmarkColumns.
+func (b *Bookmark) scanDest() []interface{} {
+	return []interface{}{&b.Id, &b.MessageId, &b.ConversationId, &b.SenderId, &b.Content, &b.SentAt,
+		&b.MessageDeleted, &b.Note, (*pq.StringArray)(&b.Tags), &b.CreatedAt, &b.UpdatedAt, &b.Version}
+}
+
+type BookmarksModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert bookmarks a message for the user. ErrRecordNotFound is returned if the message isn't
+// visible to the user in the conversation, and ErrDuplicateBookmark if it is bookmarked already.
+func (m BookmarksModel) Insert(userId int64, conversationId, messageId int, b *Bookmark) error {
+	query := `
+		INSERT INTO bookmarks AS b (user_id, message_id, conversation_id, sender_id, content, sent_at, search_language,
+			note, tags)
+		SELECT cm.user_id, m.message_id, m.conversation_id, m.sender_id, m.content, m.timestamp, m.search_language, $4, $5
+		FROM messages m
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		WHERE m.conversation_id = $1 AND m.message_id = $2 AND cm.user_id = $3
+		AND m.deleted_at IS NULL AND ` + notExpired + ` AND ` + notHidden + `
+		RETURNING ` + bookmarkColumns + `;
+		`
+	args := []interface{}{conversationId, messageId, userId, b.Note, pq.Array(b.Tags)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(b.scanDest()...)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return ErrDuplicateBookmark
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a bookmark of the user.
+func (m BookmarksModel) Get(userId, id int64) (*Bookmark, error) {
+	query := `
+		SELECT ` + bookmarkColumns + `
+		FROM bookmarks b
+		WHERE b.id = $1 AND b.user_id = $2;
+		`
+	var b Bookmark
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userId).Scan(b.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+// GetAll returns a page of the user's bookmarks. A non-empty search is matched against the saved
+// content and the note, and a non-empty tag only keeps bookmarks carrying it.
+func (m BookmarksModel) GetAll(userId int64, search, tag string, filters Filters) ([]*Bookmark, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userId, tag, filters.limit(), filters.offset()}
+	match := "TRUE"
+	if search != "" {
+		tsquery, err := ParseSearchQuery(search)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Bookmarks keep the language their message was indexed with.
+		languages, err := searchLanguages(ctx, m.DB,
+			`SELECT DISTINCT search_language::text FROM bookmarks WHERE user_id = $1;`, userId)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		args = append(args, tsquery)
+		match = searchMatch("b", languages, "$5")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+bookmarkColumns+`
+		FROM bookmarks b
+		WHERE b.user_id = $1
+		AND ($2::text = '' OR b.tags @> ARRAY[$2::text])
+		AND %s
+		ORDER BY b.%s %s, b.id %[3]s
+		LIMIT $3 OFFSET $4;
+		`, match, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	bookmarks := []*Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		if err := rows.Scan(append([]interface{}{&totalRecords}, b.scanDest()...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		bookmarks = append(bookmarks, &b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return bookmarks, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update saves the note and tags of a bookmark. ErrEditConflict is returned if the version
+// doesn't match.
+func (m BookmarksModel) Update(userId int64, b *Bookmark) error {
+	query := `
+		UPDATE bookmarks
+		SET note = $1, tags = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND user_id = $4 AND version = $5
+		RETURNING updated_at, version;
+		`
+	args := []interface{}{b.Note, pq.Array(b.Tags), b.Id, userId, b.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&b.UpdatedAt, &b.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a bookmark of the user.
+func (m BookmarksModel) Delete(userId, id int64) error {
+	query := `
+		DELETE FROM bookmarks
+		WHERE id = $1 AND user_id = $2;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// NormalizeTags trims and lowercases bookmark tags and drops empty ones, so that tags differing
+// only in case are the same tag.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+func ValidateBookmark(v *validator.Validator, b *Bookmark) {
+	v.Check(len(b.Note) <= MaxBookmarkNote, "note", fmt.Sprintf("must not be more than %d bytes long", MaxBookmarkNote))
+	v.Check(len(b.Tags) <= MaxBookmarkTags, "tags", fmt.Sprintf("must not contain more than %d tags", MaxBookmarkTags))
+	v.Check(validator.Unique(b.Tags), "tags", "must not contain duplicate values")
+	for _, tag := range b.Tags {
+		if !validator.Matches(tag, BookmarkTagRX) {
+			v.AddError("tags", "must only contain letters, digits, underscores and hyphens, up to 32 characters each")
+			break
+		}
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
 	"log"
 	"time"
@@ -20,6 +21,7 @@ type ChannelsModel struct {
 	ErrorLog *log.Logger
 }
 
+// Insert creates a channel and makes its creator the first member, in a single transaction.
 func (c ChannelsModel) Insert(channels *Channels) error {
 	// Insert a new user item into the database.
 	query := `
@@ -31,15 +33,33 @@ func (c ChannelsModel) Insert(channels *Channels) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&channels.ChannelId, &channels.UserId,
-		&channels.Name)
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&channels.ChannelId, &channels.UserId, &channels.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO channel_members (channel_id, user_id)
+		VALUES ($1, $2);`, channels.ChannelId, channels.UserId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (c ChannelsModel) Get(userId, channelId int) (*Channels, error) {
 	query := `
-		SELECT channel_id, user_id, name
-		FROM channels
-		WHERE channel_id = $1 AND user_id = $2;
+		SELECT c.channel_id, c.user_id, c.name
+		FROM channels c
+		INNER JOIN channel_members cm ON cm.channel_id = c.channel_id
+		WHERE c.channel_id = $1 AND cm.user_id = $2;
 		`
 	var channels Channels
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -48,7 +68,12 @@ func (c ChannelsModel) Get(userId, channelId int) (*Channels, error) {
 	row := c.DB.QueryRowContext(ctx, query, channelId, userId)
 	err := row.Scan(&channels.ChannelId, &channels.UserId, &channels.Name)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &channels, nil
 }
@@ -91,11 +116,13 @@ func ValidateChannel(v *validator.Validator, channel *Channels) {
 }
 
 func (c ChannelsModel) GetAll(userId int) ([]*Channels, error) {
-	// Construct the SQL query for retrieving all channels of a specific user.
+	// Construct the SQL query for retrieving all channels a specific user is a member of.
 	sqlQuery := `
-        SELECT channel_id, user_id, name
-        FROM channels
-        WHERE user_id = $1
+        SELECT c.channel_id, c.user_id, c.name
+        FROM channels c
+        INNER JOIN channel_members cm ON cm.channel_id = c.channel_id
+        WHERE cm.user_id = $1
+        ORDER BY c.channel_id
     `
 
 	// Create a context with a timeout.
@@ -3,14 +3,52 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
 )
 
+// Conversations describes a conversation together with its editable metadata. Version is
+// incremented on every metadata change and is used for optimistic locking in Update.
 type Conversations struct {
-	ConversationId int `json:"conversation_id"`
-	UserId         int `json:"user_id"`
-	FriendId       int `json:"friend_id"`
+	ConversationId int       `json:"conversation_id"`
+	UserId         int       `json:"user_id"`
+	FriendId       int       `json:"friend_id"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	Avatar         string    `json:"avatar,omitempty"`
+	MessageTTL     int       `json:"message_ttl"`
+	MentionPolicy  string    `json:"mention_policy"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"version"`
+
+	// PinnedCount is only set when a single conversation is requested.
+	PinnedCount *int `json:"pinned_count,omitempty"`
+
+	// Draft is the user's draft, only set in their list of conversations.
+	Draft *Draft `json:"draft,omitempty"`
+}
+
+// MessageTTLs are the allowed disappearing message timers in seconds, 0 turns them off.
+var MessageTTLs = map[int]string{
+	0:      "off",
+	3600:   "1 hour",
+	86400:  "1 day",
+	604800: "7 days",
+}
+
+// conversationColumns lists the columns scanned by (*Conversations).scanDest, for queries where
+// the user_conversations table is aliased as "c".
+const conversationColumns = `c.conversation_id, c.user_id, c.friend_id, c.title, c.description, c.avatar,
+		c.message_ttl, c.mention_policy, c.updated_at, c.version`
+
+// scanDest returns the scan destinations matching conversationColumns.
+func (conversation *Conversations) scanDest() []interface{} {
+	return []interface{}{&conversation.ConversationId, &conversation.UserId, &conversation.FriendId,
+		&conversation.Title, &conversation.Description, &conversation.Avatar, &conversation.MessageTTL,
+		&conversation.MentionPolicy, &conversation.UpdatedAt, &conversation.Version}
 }
 
 type ConversationsModel struct {
@@ -22,8 +60,8 @@ type ConversationsModel struct {
 func (m ConversationsModel) GetAll() ([]*Conversations, error) {
 	// Retrieve all conversations from the database.
 	query := `
-        SELECT conversation_id, user_id, friend_id
-        FROM user_conversations;
+        SELECT ` + conversationColumns + `
+        FROM user_conversations c;
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -36,7 +74,7 @@ func (m ConversationsModel) GetAll() ([]*Conversations, error) {
 	var conversations []*Conversations
 	for rows.Next() {
 		var conversation Conversations
-		if err := rows.Scan(&conversation.ConversationId, &conversation.UserId, &conversation.FriendId); err != nil {
+		if err := rows.Scan(conversation.scanDest()...); err != nil {
 			return nil, err
 		}
 		conversations = append(conversations, &conversation)
@@ -47,59 +85,189 @@ func (m ConversationsModel) GetAll() ([]*Conversations, error) {
 	return conversations, nil
 }
 
+// Insert creates a conversation and its memberships in a single transaction. The creating user
+// becomes the owner, and the friend (if any) joins as a regular member.
 func (m ConversationsModel) Insert(conversations *Conversations) error {
 	// Insert a new user item into the database.
 	query := `
-		INSERT INTO user_conversations (user_id, friend_id) 
-		VALUES ($1, $2)
-		RETURNING conversation_id, user_id, friend_id;
+		INSERT INTO user_conversations AS c (user_id, friend_id, title, description, mention_policy)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + conversationColumns + `;
 		`
-	args := []interface{}{conversations.UserId, conversations.FriendId}
+	args := []interface{}{conversations.UserId, conversations.FriendId, conversations.Title, conversations.Description,
+		conversations.MentionPolicy}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&conversations.ConversationId, &conversations.UserId,
-		&conversations.FriendId)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(conversations.scanDest()...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO conversation_members (conversation_id, user_id, role)
+		VALUES ($1, $2, 'owner');`, conversations.ConversationId, conversations.UserId)
+	if err != nil {
+		return membershipError(err)
+	}
+
+	if conversations.FriendId != 0 && conversations.FriendId != conversations.UserId {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_members (conversation_id, user_id, role)
+			VALUES ($1, $2, 'member');`, conversations.ConversationId, conversations.FriendId)
+		if err != nil {
+			return membershipError(err)
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (m ConversationsModel) Get(userId, conversationId int) (*Conversations, error) {
 	query := `
-		SELECT conversation_id, user_id, friend_id
-		FROM user_conversations
-		WHERE conversation_id = $1 AND (user_id = $2 OR friend_id = $2);
+		SELECT ` + conversationColumns + `, ` + pinnedCountColumn + `
+		FROM user_conversations c
+		INNER JOIN conversation_members cm ON cm.conversation_id = c.conversation_id
+		WHERE c.conversation_id = $1 AND cm.user_id = $2;
 		`
 	var conversations Conversations
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, query, conversationId, userId)
-	err := row.Scan(&conversations.ConversationId, &conversations.UserId, &conversations.FriendId)
+	err := row.Scan(append(conversations.scanDest(), &conversations.PinnedCount)...)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &conversations, nil
 }
 
-func (m ConversationsModel) Delete(userCheck, userId, conversationId int) error {
-	// Delete a specific user item from the database.
+// GetByID returns a conversation without checking who is asking for it. It is meant for staff
+// with the necessary permissions only.
+func (m ConversationsModel) GetByID(conversationId int) (*Conversations, error) {
+	query := `
+		SELECT ` + conversationColumns + `
+		FROM user_conversations c
+		WHERE c.conversation_id = $1;
+		`
+	var conversation Conversations
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, conversationId).Scan(conversation.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &conversation, nil
+}
+
+// Update saves the editable metadata of a conversation. The version field is checked to
+// prevent concurrent edits from overwriting each other, in which case ErrEditConflict is
+// returned. If notice isn't nil, it is sent to the conversation in the same transaction, so that
+// the change and its announcement are saved together or not at all.
+func (m ConversationsModel) Update(conversation *Conversations, messages MessagesModel, notice *Messages) error {
+	query := `
+		UPDATE user_conversations
+		SET title = $1, description = $2, avatar = $3, message_ttl = $4, mention_policy = $5, updated_at = NOW(),
+			version = version + 1
+		WHERE conversation_id = $6 AND version = $7
+		RETURNING updated_at, version
+		`
+	args := []interface{}{
+		conversation.Title,
+		conversation.Description,
+		conversation.Avatar,
+		conversation.MessageTTL,
+		conversation.MentionPolicy,
+		conversation.ConversationId,
+		conversation.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&conversation.UpdatedAt, &conversation.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if notice != nil {
+		if err := messages.insert(ctx, tx, notice); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func ValidateConversation(v *validator.Validator, conversation *Conversations) {
+	v.Check(len(conversation.Title) <= 128, "title", "must not be more than 128 bytes long")
+	v.Check(len(conversation.Description) <= 1024, "description", "must not be more than 1024 bytes long")
+	_, ok := MessageTTLs[conversation.MessageTTL]
+	v.Check(ok, "message_ttl", "must be one of 0 (off), 3600 (1h), 86400 (1d) or 604800 (7d)")
+	ValidateMentionPolicy(v, conversation.MentionPolicy)
+}
+
+// Delete removes a conversation together with its memberships and messages. Callers are
+// expected to have checked that the user is allowed to do so.
+func (m ConversationsModel) Delete(conversationId int) error {
 	query := `
 		DELETE FROM user_conversations
-		WHERE conversation_id = $1 AND (user_id = $3 OR friend_id = $3) AND (user_id = $2 OR friend_id = $2);
+		WHERE conversation_id = $1;
 		`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, conversationId, userId, userCheck)
-	return err
+	result, err := m.DB.ExecContext(ctx, query, conversationId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
 }
 
 func (m ConversationsModel) GetByUserIDWithPagination(userID int, filters Filters) ([]*Conversations, Metadata, error) {
 	// Retrieve conversations specific to the user from the database with pagination
 	query := `
-        SELECT conversation_id, user_id, friend_id
-        FROM user_conversations
-        WHERE user_id = $1 OR friend_id = $1
-        ORDER BY ` + filters.sortColumn() + ` ` + filters.sortDirection() + `
+        SELECT ` + conversationColumns + `, d.content, d.reply_to_message_id, d.updated_at, d.version
+        FROM user_conversations c
+        INNER JOIN conversation_members cm ON cm.conversation_id = c.conversation_id
+        LEFT JOIN drafts d ON d.conversation_id = c.conversation_id AND d.user_id = cm.user_id
+        WHERE cm.user_id = $1
+        ORDER BY c.` + filters.sortColumn() + ` ` + filters.sortDirection() + `
         LIMIT $2 OFFSET $3;
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -113,9 +281,25 @@ func (m ConversationsModel) GetByUserIDWithPagination(userID int, filters Filter
 	var conversations []*Conversations
 	for rows.Next() {
 		var conversation Conversations
-		if err := rows.Scan(&conversation.ConversationId, &conversation.UserId, &conversation.FriendId); err != nil {
+		var draft struct {
+			content   sql.NullString
+			replyTo   *int
+			updatedAt sql.NullTime
+			version   sql.NullInt32
+		}
+		dest := append(conversation.scanDest(), &draft.content, &draft.replyTo, &draft.updatedAt, &draft.version)
+		if err := rows.Scan(dest...); err != nil {
 			return nil, Metadata{}, err
 		}
+		if draft.content.Valid {
+			conversation.Draft = &Draft{
+				ConversationId: conversation.ConversationId,
+				Content:        draft.content.String,
+				ReplyTo:        draft.replyTo,
+				UpdatedAt:      draft.updatedAt.Time,
+				Version:        int(draft.version.Int32),
+			}
+		}
 		conversations = append(conversations, &conversation)
 	}
 	if err := rows.Err(); err != nil {
@@ -124,7 +308,7 @@ func (m ConversationsModel) GetByUserIDWithPagination(userID int, filters Filter
 
 	// Retrieve total number of records for the user
 	var totalRecords int
-	err = m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_conversations WHERE user_id = $1 OR friend_id = $1;",
+	err = m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversation_members WHERE user_id = $1;",
 		userID).Scan(&totalRecords)
 	if err != nil {
 		return nil, Metadata{}, err
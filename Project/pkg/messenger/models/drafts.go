@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+// MaxDraftLength is the longest draft content that is stored, in bytes.
+const MaxDraftLength = 16384
+
+// Draft is the message a user is writing in a conversation.
+type Draft struct {
+	ConversationId int       `json:"conversation_id"`
+	Content        string    `json:"content"`
+	ReplyTo        *int      `json:"reply_to_message_id,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"version"`
+}
+
+// Empty reports whether there is nothing worth keeping in the draft.
+func (d *Draft) Empty() bool {
+	return d.Content == "" && d.ReplyTo == nil
+}
+
+type DraftsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get returns the user's draft in a conversation.
+func (m DraftsModel) Get(userId int64, conversationId int) (*Draft, error) {
+	query := `
+		SELECT conversation_id, content, reply_to_message_id, updated_at, version
+		FROM drafts
+		WHERE user_id = $1 AND conversation_id = $2;
+		`
+	var d Draft
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userId, conversationId).Scan(&d.ConversationId, &d.Content, &d.ReplyTo,
+		&d.UpdatedAt, &d.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+// Save stores the user's draft in a conversation, replacing the previous one. ErrRecordNotFound
+// is returned if the user isn't a member of the conversation, and ErrInvalidReply if the draft
+// replies to a message that isn't in it.
+func (m DraftsModel) Save(userId int64, d *Draft) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if d.ReplyTo != nil {
+		if err := checkReplyTarget(ctx, tx, strconv.Itoa(d.ConversationId), *d.ReplyTo); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO drafts (user_id, conversation_id, content, reply_to_message_id)
+		SELECT cm.user_id, cm.conversation_id, $3, $4
+		FROM conversation_members cm
+		WHERE cm.user_id = $1 AND cm.conversation_id = $2
+		ON CONFLICT (user_id, conversation_id) DO UPDATE
+		SET content = EXCLUDED.content, reply_to_message_id = EXCLUDED.reply_to_message_id, updated_at = NOW(),
+			version = drafts.version + 1
+		RETURNING updated_at, version;
+		`
+	err = tx.QueryRowContext(ctx, query, userId, d.ConversationId, d.Content, d.ReplyTo).Scan(&d.UpdatedAt, &d.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete discards the user's draft in a conversation.
+func (m DraftsModel) Delete(userId int64, conversationId int) error {
+	query := `
+		DELETE FROM drafts
+		WHERE user_id = $1 AND conversation_id = $2;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userId, conversationId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func ValidateDraft(v *validator.Validator, d *Draft) {
+	v.Check(len(d.Content) <= MaxDraftLength, "content", fmt.Sprintf("must not be more than %d bytes long", MaxDraftLength))
+	v.Check(d.ReplyTo == nil || *d.ReplyTo > 0, "reply_to_message_id", "must be a valid message ID")
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/KarenMirzayan/Project/pkg/richtext"
+)
+
+// Formats the content of a message can be sent in. Markdown is parsed into plain text and
+// formatting entities, plain content may come with entities of its own.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// MaxMessageLength is the longest content of a message, in characters.
+const MaxMessageLength = 4096
+
+// FormattingEntities are the formatting of a message, stored as JSON in messages.entities.
+type FormattingEntities []richtext.Entity
+
+// Scan implements sql.Scanner.
+func (e *FormattingEntities) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, e)
+	case string:
+		return json.Unmarshal([]byte(src), e)
+	default:
+		return fmt.Errorf("cannot scan %T into FormattingEntities", src)
+	}
+}
+
+// Value implements driver.Valuer.
+func (e FormattingEntities) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(e)
+	return string(data), err
+}
+
+// equal reports whether both have the same entities in the same order.
+func (e FormattingEntities) equal(other FormattingEntities) bool {
+	if len(e) != len(other) {
+		return false
+	}
+	for i := range e {
+		if e[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetContent sets the content of the message in the given format. Markdown is parsed, and any
+// entities passed along with it are ignored.
+func (message *Messages) SetContent(content, format string, entities []richtext.Entity) {
+	if format == FormatMarkdown {
+		content, entities = richtext.ParseMarkdown(content)
+	}
+	message.Content = content
+	message.Entities = entities
+}
+
+// render updates the HTML rendering of the message from its content and entities. Messages
+// without formatting don't get one.
+func (message *Messages) render() {
+	message.RenderedHTML = nil
+	if len(message.Entities) > 0 {
+		rendered := richtext.RenderHTML(message.Content, message.Entities)
+		message.RenderedHTML = &rendered
+	}
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Limits on a single forward request. Every message is copied into every target conversation.
+const (
+	MaxForwardMessages = 50
+	MaxForwardTargets  = 10
+)
+
+var (
+	// ErrInvalidForward is returned when a message to forward isn't a visible text message of the
+	// source conversation.
+	ErrInvalidForward = errors.New("invalid forwarded message")
+
+	// ErrInvalidForwardTarget is returned when the user isn't a member of a target conversation.
+	ErrInvalidForwardTarget = errors.New("invalid forward target")
+)
+
+// Forward copies messages of a conversation the user belongs to into other conversations the
+// user belongs to, in their original order. The copies are sent by the user and record the
+// original sender, conversation, message and timestamp, unless the original sender disallows
+// forward attribution. Forwarding a forwarded copy keeps pointing at the first original.
+// Attachments are linked to the copies rather than copied. Everything happens in a single
+// transaction, so either all copies are created or none.
+func (m MessagesModel) Forward(userId int64, conversationId int, messageIds, targetIds []int) ([]*Messages, error) {
+	// Sending to a conversation locks it until the transaction ends. Taking the locks in the order
+	// of the conversation IDs keeps concurrent forwards to overlapping targets from deadlocking.
+	targets := append([]int(nil), targetIds...)
+	sort.Ints(targets)
+	for i := 1; i < len(targets); {
+		if targets[i] == targets[i-1] {
+			targets = append(targets[:i], targets[i+1:]...)
+		} else {
+			i++
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var memberships int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_members
+		WHERE user_id = $1 AND conversation_id = ANY($2::int[]);`, userId, pq.Array(targets)).Scan(&memberships)
+	if err != nil {
+		return nil, err
+	}
+	if memberships != len(targets) {
+		return nil, ErrInvalidForwardTarget
+	}
+
+	// The attribution setting of whoever wrote the original applies, also to copies of copies.
+	query := `
+		SELECT m.message_id, m.content, m.entities, m.forwarded,
+			COALESCE(m.forwarded_from_sender_id, CASE WHEN NOT m.forwarded THEN m.sender_id END),
+			COALESCE(m.forwarded_from_conversation_id, CASE WHEN NOT m.forwarded THEN m.conversation_id END),
+			COALESCE(m.forwarded_from_message_id, CASE WHEN NOT m.forwarded THEN m.message_id END),
+			COALESCE(m.forwarded_from_timestamp, CASE WHEN NOT m.forwarded THEN m.timestamp AT TIME ZONE 'UTC' END),
+			COALESCE(u.allow_forward_attribution, FALSE)
+		FROM messages m
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		LEFT JOIN users u ON u.id = COALESCE(m.forwarded_from_sender_id, CASE WHEN NOT m.forwarded THEN m.sender_id END)
+		WHERE m.conversation_id = $1 AND cm.user_id = $2 AND m.message_id = ANY($3::int[])
+		AND m.type = 'text' AND m.deleted_at IS NULL AND ` + notExpired + ` AND ` + notHidden + `
+		ORDER BY m.timestamp, m.message_id;
+		`
+	rows, err := tx.QueryContext(ctx, query, conversationId, userId, pq.Array(messageIds))
+	if err != nil {
+		return nil, err
+	}
+
+	type source struct {
+		messageId   string
+		copy        Messages
+		attribution bool
+	}
+	var sources []source
+	for rows.Next() {
+		var s source
+		err := rows.Scan(&s.messageId, &s.copy.Content, &s.copy.Entities, &s.copy.Forwarded,
+			&s.copy.ForwardedFromSenderId, &s.copy.ForwardedFromConversationId, &s.copy.ForwardedFromMessageId,
+			&s.copy.ForwardedFromTimestamp, &s.attribution)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	// The rows have to be closed before the transaction can run the next statement.
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(sources) != len(messageIds) {
+		return nil, ErrInvalidForward
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	var forwarded []*Messages
+	for _, targetId := range targets {
+		for _, s := range sources {
+			message := s.copy
+			message.ConversationId = strconv.Itoa(targetId)
+			message.SenderId = int(userId)
+			message.Timestamp = timestamp
+			message.Forwarded = true
+			if !s.attribution {
+				message.ForwardedFromSenderId = nil
+				message.ForwardedFromConversationId = nil
+				message.ForwardedFromMessageId = nil
+				message.ForwardedFromTimestamp = nil
+			}
+
+			if err := m.insert(ctx, tx, &message); err != nil {
+				if errors.Is(err, ErrRecordNotFound) {
+					return nil, ErrInvalidForwardTarget
+				}
+				return nil, err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO message_attachments (message_id, attachment_id, position)
+				SELECT $1, attachment_id, position
+				FROM message_attachments
+				WHERE message_id = $2;`, message.MessageId, s.messageId)
+			if err != nil {
+				return nil, err
+			}
+
+			forwarded = append(forwarded, &message)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return forwarded, nil
+}
+
+func ValidateForward(v *validator.Validator, messageIds, targetIds []int) {
+	v.Check(len(messageIds) > 0, "message_ids", "must contain at least one message")
+	v.Check(len(messageIds) <= MaxForwardMessages, "message_ids",
+		fmt.Sprintf("must not contain more than %d messages", MaxForwardMessages))
+	v.Check(validator.Unique(messageIds), "message_ids", "must not contain duplicate values")
+	v.Check(len(targetIds) > 0, "conversation_ids", "must contain at least one conversation")
+	v.Check(len(targetIds) <= MaxForwardTargets, "conversation_ids",
+		fmt.Sprintf("must not contain more than %d conversations", MaxForwardTargets))
+	v.Check(validator.Unique(targetIds), "conversation_ids", "must not contain duplicate values")
+	for _, id := range targetIds {
+		if id <= 0 {
+			v.AddError("conversation_ids", "must only contain valid conversation IDs")
+			break
+		}
+	}
+	for _, id := range messageIds {
+		if id <= 0 {
+			v.AddError("message_ids", "must only contain valid message IDs")
+			break
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCursor is returned when a search cursor can't be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// SearchCursor marks the position after the last result of a page. Results are ordered newest
+// first, so the next page continues with messages older than the cursor.
+type SearchCursor struct {
+	Timestamp time.Time
+	MessageId int
+}
+
+// Encode returns the opaque form of the cursor handed to clients.
+func (c SearchCursor) Encode() string {
+	raw := c.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.MessageId)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeSearchCursor parses a cursor returned by Encode.
+func DecodeSearchCursor(s string) (*SearchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	timestamp, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor SearchCursor
+	if cursor.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.MessageId, err = strconv.Atoi(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// SearchFilters narrows down a search across conversations. Nil and zero fields don't filter.
+type SearchFilters struct {
+	Query         string
+	SenderId      *int
+	From, To      *time.Time
+	HasAttachment bool
+	Cursor        *SearchCursor
+	Limit         int
+}
+
+// SearchGroup holds the results of a page that belong to one conversation. MatchCount counts all
+// of the conversation's matches, not just the ones on this page.
+type SearchGroup struct {
+	ConversationId int         `json:"conversation_id"`
+	Title          string      `json:"title"`
+	MatchCount     int         `json:"match_count"`
+	Messages       []*Messages `json:"messages"`
+}
+
+// SearchResults is a page of results of a search across conversations.
+type SearchResults struct {
+	Groups     []*SearchGroup `json:"results"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Search looks for messages in every conversation the user belongs to. Results are paginated
+// with a keyset cursor on (timestamp, message_id), so pages stay stable while new messages
+// arrive, and grouped by conversation in the order of their newest result on the page.
+func (m MessagesModel) Search(userId int, filters SearchFilters) (*SearchResults, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	args := []interface{}{userId, filters.SenderId, filters.From, filters.To, filters.HasAttachment}
+	match, rank, snippet := "TRUE", "0::real", "''"
+	var languages []string
+	if filters.Query != "" {
+		tsquery, err := ParseSearchQuery(filters.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		// Each message is searched in the language it was indexed with, like in GetAll.
+		languages, err = searchLanguages(ctx, m.DB, `
+			SELECT DISTINCT m.search_language::text
+			FROM messages m
+			INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $1;`,
+			userId)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, tsquery)
+		match = searchMatch("m", languages, "$6")
+		rank = searchRank("m", languages, "$6")
+	}
+
+	// Both queries below share the filters, only the page query applies the cursor.
+	from := `
+		FROM messages m
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $1
+		WHERE ` + match + `
+		AND m.deleted_at IS NULL
+		AND ` + notExpired + `
+		AND ` + notHidden + `
+		AND ($2::int IS NULL OR m.sender_id = $2)
+		AND ($3::timestamptz IS NULL OR m.timestamp >= $3)
+		AND ($4::timestamptz IS NULL OR m.timestamp < $4)
+		AND (NOT $5::bool OR EXISTS (SELECT 1 FROM message_attachments ma WHERE ma.message_id = m.message_id))`
+
+	results := &SearchResults{Groups: []*SearchGroup{}}
+	counts := make(map[int]int)
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT m.conversation_id, COUNT(*)`+from+` GROUP BY m.conversation_id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var conversationId, count int
+		if err := rows.Scan(&conversationId, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		counts[conversationId] = count
+		results.Total += count
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if results.Total == 0 {
+		return results, nil
+	}
+
+	// Only the page query makes snippets, the count query mustn't be passed their options.
+	if filters.Query != "" {
+		args = append(args, headlineOptions)
+		snippet = searchSnippet("m", languages, "$6", "$"+strconv.Itoa(len(args)))
+	}
+
+	cursor := "TRUE"
+	if filters.Cursor != nil {
+		n := len(args)
+		args = append(args, filters.Cursor.Timestamp, filters.Cursor.MessageId)
+		cursor = fmt.Sprintf("(m.timestamp, m.message_id) < ($%d::timestamptz, $%d::int)", n+1, n+2)
+	}
+	args = append(args, filters.Limit+1)
+
+	query := `
+		SELECT ` + messageColumns + `, ` + replyCountColumn + `, ` + rank + `, ` + snippet + `,
+			COALESCE((SELECT c.title FROM user_conversations c WHERE c.conversation_id = m.conversation_id), '')` + from + `
+		AND ` + cursor + `
+		ORDER BY m.timestamp DESC, m.message_id DESC
+		LIMIT $` + strconv.Itoa(len(args)) + `;`
+
+	rows, err = m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var page []*Messages
+	titles := make(map[string]string)
+	for rows.Next() {
+		var message Messages
+		var title string
+		dest := append(message.scanDest(), &message.ReplyCount, &message.Rank, &message.Snippet, &title)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if message.Snippet != "" {
+			message.Snippet = highlightSnippet(message.Snippet)
+		}
+		titles[message.ConversationId] = title
+		page = append(page, &message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The extra row only tells us that there is another page.
+	if len(page) > filters.Limit {
+		page = page[:filters.Limit]
+		last := page[len(page)-1]
+
+		timestamp, err := time.Parse(time.RFC3339Nano, last.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(last.MessageId)
+		if err != nil {
+			return nil, err
+		}
+		results.NextCursor = SearchCursor{Timestamp: timestamp, MessageId: id}.Encode()
+	}
+
+	groups := make(map[string]*SearchGroup)
+	for _, message := range page {
+		group, ok := groups[message.ConversationId]
+		if !ok {
+			conversationId, _ := strconv.Atoi(message.ConversationId)
+			group = &SearchGroup{
+				ConversationId: conversationId,
+				Title:          titles[message.ConversationId],
+				MatchCount:     counts[conversationId],
+			}
+			groups[message.ConversationId] = group
+			results.Groups = append(results.Groups, group)
+		}
+		group.Messages = append(group.Messages, message)
+	}
+
+	return results, nil
+}
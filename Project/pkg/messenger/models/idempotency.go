@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"unicode"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+// MaxIdempotencyKeyLength is the longest idempotency key a client may send.
+const MaxIdempotencyKeyLength = 255
+
+// ValidateIdempotencyKey checks a client supplied idempotency key. Keys are opaque to the server,
+// UUIDs are a good choice, but must be printable so that they can be logged.
+func ValidateIdempotencyKey(v *validator.Validator, field, key string) {
+	v.Check(len(key) <= MaxIdempotencyKeyLength, field, "must not be more than 255 bytes long")
+	for _, r := range key {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			v.AddError(field, "must only contain printable characters without spaces")
+			return
+		}
+	}
+}
+
+// claimIdempotencyKey records the idempotency key of a message about to be sent, as part of the
+// transaction sending it. It reports false if the sender already sent a message with the key to
+// the conversation within the retention window, and fills messages in with that message instead.
+// Concurrent retries wait for the first one to commit or roll back.
+func (m MessagesModel) claimIdempotencyKey(ctx context.Context, tx *sql.Tx, messages *Messages) (bool, error) {
+	// A key past the retention window counts as unused, even if the reaper hasn't deleted it yet.
+	query := `
+		INSERT INTO message_idempotency_keys AS k (sender_id, conversation_id, key)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (sender_id, conversation_id, key) DO UPDATE
+		SET message_id = NULL, created_at = NOW()
+		WHERE k.created_at <= NOW() - make_interval(secs => $4);
+		`
+	result, err := tx.ExecContext(ctx, query, messages.SenderId, messages.ConversationId, messages.IdempotencyKey,
+		m.IdempotencyKeyTTL.Seconds())
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected > 0 {
+		return true, nil
+	}
+
+	query = `
+		SELECT ` + messageColumns + `, ` + replyCountColumn + `
+		FROM message_idempotency_keys k
+		INNER JOIN messages m ON m.message_id = k.message_id
+		WHERE k.sender_id = $1 AND k.conversation_id = $2 AND k.key = $3;
+		`
+	err = tx.QueryRowContext(ctx, query, messages.SenderId, messages.ConversationId, messages.IdempotencyKey).
+		Scan(append(messages.scanDest(), &messages.ReplyCount)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes up to limit idempotency keys past the retention window and
+// returns how many it deleted.
+func (m MessagesModel) DeleteExpiredIdempotencyKeys(limit int) (int, error) {
+	query := `
+		DELETE FROM message_idempotency_keys
+		WHERE (sender_id, conversation_id, key) IN (
+			SELECT sender_id, conversation_id, key
+			FROM message_idempotency_keys
+			WHERE created_at <= NOW() - make_interval(secs => $2)
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		);
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, limit, m.IdempotencyKeyTTL.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,424 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+// Invite request statuses.
+const (
+	InviteRequestPending  = "pending"
+	InviteRequestApproved = "approved"
+	InviteRequestRejected = "rejected"
+)
+
+// Results of joining through an invite.
+const (
+	JoinJoined  = "joined"
+	JoinPending = "pending"
+	JoinMember  = "already_member"
+)
+
+var (
+	// ErrInvalidInvite is returned when an invite code is unknown, revoked, expired or used up.
+	ErrInvalidInvite = errors.New("invalid invite")
+)
+
+// InviteTarget identifies the conversation or channel an invite belongs to. Exactly one of the
+// two fields is set.
+type InviteTarget struct {
+	ConversationId *int `json:"conversation_id,omitempty"`
+	ChannelId      *int `json:"channel_id,omitempty"`
+}
+
+// Invite is a shareable link to join a conversation or channel. Like tokens, only the SHA-256
+// hash of the code is stored, the plaintext is only available right after creation.
+type Invite struct {
+	ID int64 `json:"id"`
+	InviteTarget
+	Plaintext        string     `json:"code,omitempty"`
+	Hash             []byte     `json:"-"`
+	CreatedBy        int64      `json:"created_by"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Expiry           *time.Time `json:"expiry,omitempty"`
+	MaxUses          *int       `json:"max_uses,omitempty"`
+	Uses             int        `json:"uses"`
+	RequiresApproval bool       `json:"requires_approval"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// InvitePreview is what a user sees about an invite before joining.
+type InvitePreview struct {
+	InviteTarget
+	Title            string     `json:"title"`
+	MemberCount      int        `json:"member_count"`
+	RequiresApproval bool       `json:"requires_approval"`
+	Expiry           *time.Time `json:"expiry,omitempty"`
+}
+
+// InviteRequest is a request to join through an invite that requires approval.
+type InviteRequest struct {
+	InviteId  int64      `json:"invite_id"`
+	UserId    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+type InvitesModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// New generates a random invite code for the invite and inserts the invite record.
+func (m InvitesModel) New(invite *Invite) error {
+	plaintext, hash, err := generateCode()
+	if err != nil {
+		return err
+	}
+	invite.Plaintext = plaintext
+	invite.Hash = hash
+
+	query := `
+		INSERT INTO invites (hash, conversation_id, channel_id, created_by, expiry, max_uses, requires_approval)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, uses;
+		`
+	args := []interface{}{invite.Hash, invite.ConversationId, invite.ChannelId, invite.CreatedBy, invite.Expiry,
+		invite.MaxUses, invite.RequiresApproval}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invite.ID, &invite.CreatedAt, &invite.Uses)
+}
+
+// GetAll returns the invites of a conversation or channel, newest first.
+func (m InvitesModel) GetAll(target InviteTarget) ([]*Invite, error) {
+	query := `
+		SELECT id, conversation_id, channel_id, created_by, created_at, expiry, max_uses, uses,
+			requires_approval, revoked_at
+		FROM invites
+		WHERE conversation_id IS NOT DISTINCT FROM $1 AND channel_id IS NOT DISTINCT FROM $2
+		ORDER BY id DESC;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, target.ConversationId, target.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var invites []*Invite
+	for rows.Next() {
+		var invite Invite
+		if err := rows.Scan(&invite.ID, &invite.ConversationId, &invite.ChannelId, &invite.CreatedBy,
+			&invite.CreatedAt, &invite.Expiry, &invite.MaxUses, &invite.Uses, &invite.RequiresApproval,
+			&invite.RevokedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, &invite)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// Revoke marks an invite as revoked so it can't be used anymore.
+func (m InvitesModel) Revoke(target InviteTarget, inviteId int64) error {
+	query := `
+		UPDATE invites
+		SET revoked_at = NOW()
+		WHERE id = $1 AND conversation_id IS NOT DISTINCT FROM $2 AND channel_id IS NOT DISTINCT FROM $3
+		AND revoked_at IS NULL;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, inviteId, target.ConversationId, target.ChannelId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Preview returns the title and member count of the conversation or channel a still usable
+// invite code points to.
+func (m InvitesModel) Preview(code string) (*InvitePreview, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	query := `
+		SELECT i.conversation_id, i.channel_id, i.requires_approval, i.expiry,
+			COALESCE(c.title, ch.name, ''),
+			CASE
+				WHEN i.conversation_id IS NOT NULL
+					THEN (SELECT COUNT(*) FROM conversation_members WHERE conversation_id = i.conversation_id)
+				ELSE (SELECT COUNT(*) FROM channel_members WHERE channel_id = i.channel_id)
+			END
+		FROM invites i
+		LEFT JOIN user_conversations c ON c.conversation_id = i.conversation_id
+		LEFT JOIN channels ch ON ch.channel_id = i.channel_id
+		WHERE i.hash = $1 AND ` + usableInvite + `;
+		`
+	var preview InvitePreview
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&preview.ConversationId, &preview.ChannelId,
+		&preview.RequiresApproval, &preview.Expiry, &preview.Title, &preview.MemberCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidInvite
+		default:
+			return nil, err
+		}
+	}
+	return &preview, nil
+}
+
+// usableInvite is the condition an invite row "i" has to meet to be used for joining.
+const usableInvite = `i.revoked_at IS NULL
+		AND (i.expiry IS NULL OR i.expiry > NOW())
+		AND (i.max_uses IS NULL OR i.uses < i.max_uses)`
+
+// Join uses an invite code for the given user. If the invite requires approval a pending join
+// request is recorded instead. It returns the invite target together with one of JoinJoined,
+// JoinPending or JoinMember.
+func (m InvitesModel) Join(code string, userId int64) (*InviteTarget, string, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the invite row so that concurrent joins can't go over max_uses.
+	var (
+		inviteId         int64
+		target           InviteTarget
+		requiresApproval bool
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT i.id, i.conversation_id, i.channel_id, i.requires_approval
+		FROM invites i
+		WHERE i.hash = $1 AND `+usableInvite+`
+		FOR UPDATE;`, hash[:]).Scan(&inviteId, &target.ConversationId, &target.ChannelId, &requiresApproval)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrInvalidInvite
+		default:
+			return nil, "", err
+		}
+	}
+
+	if requiresApproval {
+		// Users who are already in don't need to ask for approval again.
+		var member bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $3)
+				OR EXISTS (SELECT 1 FROM channel_members WHERE channel_id = $2 AND user_id = $3);`,
+			target.ConversationId, target.ChannelId, userId).Scan(&member)
+		if err != nil {
+			return nil, "", err
+		}
+		if member {
+			return &target, JoinMember, nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO invite_requests (invite_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT (invite_id, user_id) DO UPDATE SET status = 'pending', created_at = NOW(), decided_at = NULL
+			WHERE invite_requests.status = 'rejected';`, inviteId, userId)
+		if err != nil {
+			return nil, "", err
+		}
+		return &target, JoinPending, tx.Commit()
+	}
+
+	status, err := addInviteMember(ctx, tx, inviteId, target, userId)
+	if err != nil {
+		return nil, "", err
+	}
+	return &target, status, tx.Commit()
+}
+
+// GetRequests returns the join requests of an invite, pending ones first.
+func (m InvitesModel) GetRequests(target InviteTarget, inviteId int64) ([]*InviteRequest, error) {
+	query := `
+		SELECT r.invite_id, r.user_id, u.name, r.status, r.created_at, r.decided_at
+		FROM invite_requests r
+		INNER JOIN invites i ON i.id = r.invite_id
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE i.id = $1 AND i.conversation_id IS NOT DISTINCT FROM $2 AND i.channel_id IS NOT DISTINCT FROM $3
+		ORDER BY r.status <> 'pending', r.created_at;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, inviteId, target.ConversationId, target.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var requests []*InviteRequest
+	for rows.Next() {
+		var request InviteRequest
+		if err := rows.Scan(&request.InviteId, &request.UserId, &request.Name, &request.Status,
+			&request.CreatedAt, &request.DecidedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &request)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// DecideRequest approves or rejects a pending join request. Approving adds the user to the
+// conversation or channel and counts as a use of the invite, so it fails with ErrInvalidInvite
+// if the invite can't be used anymore.
+func (m InvitesModel) DecideRequest(target InviteTarget, inviteId, userId int64, approve bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status := InviteRequestRejected
+	if approve {
+		status = InviteRequestApproved
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE invite_requests r
+		SET status = $1, decided_at = NOW()
+		FROM invites i
+		WHERE i.id = r.invite_id AND r.invite_id = $2 AND r.user_id = $3 AND r.status = 'pending'
+		AND i.conversation_id IS NOT DISTINCT FROM $4 AND i.channel_id IS NOT DISTINCT FROM $5;`,
+		status, inviteId, userId, target.ConversationId, target.ChannelId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	if approve {
+		var id int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT i.id FROM invites i
+			WHERE i.id = $1 AND `+usableInvite+`
+			FOR UPDATE;`, inviteId).Scan(&id)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrInvalidInvite
+			default:
+				return err
+			}
+		}
+
+		if _, err := addInviteMember(ctx, tx, inviteId, target, userId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addInviteMember adds the user to the invite target and counts the use of the invite. Users
+// who already are members don't use up the invite.
+func addInviteMember(ctx context.Context, tx *sql.Tx, inviteId int64, target InviteTarget, userId int64) (string, error) {
+	var result sql.Result
+	var err error
+	if target.ConversationId != nil {
+		result, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_members (conversation_id, user_id, role)
+			VALUES ($1, $2, 'member')
+			ON CONFLICT DO NOTHING;`, *target.ConversationId, userId)
+	} else {
+		result, err = tx.ExecContext(ctx, `
+			INSERT INTO channel_members (channel_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING;`, *target.ChannelId, userId)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return JoinMember, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE invites SET uses = uses + 1 WHERE id = $1;`, inviteId)
+	if err != nil {
+		return "", err
+	}
+	return JoinJoined, nil
+}
+
+// MaxInviteLifetime is the longest an invite may stay valid, in seconds.
+const MaxInviteLifetime = 366 * 24 * 60 * 60
+
+// ValidateInviteLifetime checks the number of seconds an invite stays valid. It is checked before
+// the expiry is worked out, which would overflow for huge values.
+func ValidateInviteLifetime(v *validator.Validator, seconds int) {
+	v.Check(seconds > 0, "expires_in_seconds", "must be greater than 0")
+	v.Check(seconds <= MaxInviteLifetime, "expires_in_seconds", "must not be more than a year")
+}
+
+func ValidateInvite(v *validator.Validator, invite *Invite) {
+	if invite.MaxUses != nil {
+		v.Check(*invite.MaxUses > 0, "max_uses", "must be greater than 0")
+		v.Check(*invite.MaxUses <= 100_000, "max_uses", "must be a maximum of 100000")
+	}
+}
+
+func ValidateInviteCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 26, "code", "must be 26 bytes long")
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/linkpreview"
+	"github.com/KarenMirzayan/Project/pkg/richtext"
+	"github.com/lib/pq"
+)
+
+// Statuses of the link preview of a message.
+const (
+	LinkPreviewPending = "pending"
+	LinkPreviewDone    = "done"
+	LinkPreviewFailed  = "failed"
+)
+
+// LinkPreview is what the page behind a link says about itself. Failed previews are cached so
+// that the page isn't fetched again right away, but never shown.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Failed      bool      `json:"-"`
+}
+
+// PendingLinkPreview is a message whose link preview is still to be generated.
+type PendingLinkPreview struct {
+	MessageId      int
+	ConversationId int
+	URL            string
+	Attempts       int
+}
+
+type LinkPreviewsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// previewURL returns the link of a text message to preview: the first link in its formatting,
+// or else the first URL in its text. It returns "" if the message has nothing to preview.
+func previewURL(message *Messages) string {
+	if message.Type != MessageTypeText {
+		return ""
+	}
+	for _, e := range richtext.Sorted(message.Entities) {
+		if e.Type == richtext.TypeLink && (strings.HasPrefix(e.URL, "http://") || strings.HasPrefix(e.URL, "https://")) {
+			return e.URL
+		}
+	}
+	if urls := linkpreview.ExtractURLs(message.Content); len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
+
+// queueLinkPreview queues the link of a message for the preview worker as part of the
+// transaction that writes the message. An edit that changes the link queues the new one, and one
+// that removes it drops the preview.
+func queueLinkPreview(ctx context.Context, tx *sql.Tx, message *Messages) error {
+	url := previewURL(message)
+	if url == "" {
+		_, err := tx.ExecContext(ctx, `DELETE FROM message_link_previews WHERE message_id = $1;`, message.MessageId)
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO message_link_previews (message_id, url)
+		VALUES ($1, $2)
+		ON CONFLICT (message_id) DO UPDATE
+		SET url = EXCLUDED.url, status = 'pending', attempts = 0, claimed_at = NULL, created_at = NOW()
+		WHERE message_link_previews.url <> EXCLUDED.url;`, message.MessageId, url)
+	return err
+}
+
+// Claim marks up to limit pending previews as being worked on and returns them, oldest first.
+// Previews claimed longer than stale ago are claimed again, unless they already had maxAttempts
+// attempts. Concurrent workers never claim the same preview.
+func (m LinkPreviewsModel) Claim(limit int, stale time.Duration, maxAttempts int) ([]*PendingLinkPreview, error) {
+	query := `
+		UPDATE message_link_previews p
+		SET claimed_at = NOW(), attempts = p.attempts + 1
+		FROM messages m
+		WHERE m.message_id = p.message_id AND p.message_id IN (
+			SELECT message_id FROM message_link_previews
+			WHERE status = 'pending' AND attempts < $3
+			AND (claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $2))
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING p.message_id, m.conversation_id, p.url, p.attempts;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, stale.Seconds(), maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var pending []*PendingLinkPreview
+	for rows.Next() {
+		var p PendingLinkPreview
+		if err := rows.Scan(&p.MessageId, &p.ConversationId, &p.URL, &p.Attempts); err != nil {
+			return nil, err
+		}
+		pending = append(pending, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Cached returns the cached preview of a URL if it was fetched within ttl, or within failureTTL
+// for failed fetches. ErrRecordNotFound is returned if there is none.
+func (m LinkPreviewsModel) Cached(url string, ttl, failureTTL time.Duration) (*LinkPreview, error) {
+	query := `
+		SELECT url, title, description, image_url, site_name, fetched_at, failed
+		FROM link_previews
+		WHERE url = $1
+		AND fetched_at > NOW() - make_interval(secs => CASE WHEN failed THEN $3 ELSE $2 END);
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p LinkPreview
+	err := m.DB.QueryRowContext(ctx, query, url, ttl.Seconds(), failureTTL.Seconds()).Scan(&p.URL, &p.Title,
+		&p.Description, &p.ImageURL, &p.SiteName, &p.FetchedAt, &p.Failed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// Store caches a freshly fetched preview, replacing the previous one of its URL.
+func (m LinkPreviewsModel) Store(p *LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (url, title, description, image_url, site_name, failed, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (url) DO UPDATE
+		SET title = EXCLUDED.title, description = EXCLUDED.description, image_url = EXCLUDED.image_url,
+			site_name = EXCLUDED.site_name, failed = EXCLUDED.failed, fetched_at = EXCLUDED.fetched_at
+		RETURNING fetched_at;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, p.URL, p.Title, p.Description, p.ImageURL, p.SiteName,
+		p.Failed).Scan(&p.FetchedAt)
+}
+
+// Complete records the outcome of generating the preview of a message. It reports false if the
+// message was edited to another link or deleted in the meantime, in which case there is nothing
+// to announce.
+func (m LinkPreviewsModel) Complete(messageId int, url, status string) (bool, error) {
+	query := `
+		UPDATE message_link_previews
+		SET status = $3
+		WHERE message_id = $1 AND url = $2 AND status = 'pending';
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, messageId, url, status)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// ForMessages returns the generated previews of the given messages, keyed by message ID.
+// Messages whose preview is pending or failed have none.
+func (m LinkPreviewsModel) ForMessages(messageIds []string) (map[string]*LinkPreview, error) {
+	previews := make(map[string]*LinkPreview)
+	if len(messageIds) == 0 {
+		return previews, nil
+	}
+
+	query := `
+		SELECT mp.message_id, p.url, p.title, p.description, p.image_url, p.site_name, p.fetched_at
+		FROM message_link_previews mp
+		INNER JOIN link_previews p ON p.url = mp.url
+		WHERE mp.message_id = ANY($1::int[]) AND mp.status = 'done' AND NOT p.failed;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(messageIds))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var messageId string
+		var p LinkPreview
+		if err := rows.Scan(&messageId, &p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName, &p.FetchedAt); err != nil {
+			return nil, err
+		}
+		previews[messageId] = &p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return previews, nil
+}
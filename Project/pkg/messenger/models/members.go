@@ -0,0 +1,296 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Conversation roles. An owner can do everything an admin can, and an admin can do everything a
+// member can.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	// ErrDuplicateMember is returned when a user is added to a conversation they already belong to.
+	ErrDuplicateMember = errors.New("duplicate member")
+
+	// ErrUnknownUser is returned when a membership references a user that doesn't exist.
+	ErrUnknownUser = errors.New("unknown user")
+)
+
+// roleRanks orders the conversation roles from least to most privileged.
+var roleRanks = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of the required role.
+func RoleAtLeast(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required] && roleRanks[role] > 0
+}
+
+// Member describes a user's membership in a conversation.
+type Member struct {
+	ConversationId int       `json:"conversation_id"`
+	UserId         int64     `json:"user_id"`
+	Name           string    `json:"name"`
+	Role           string    `json:"role"`
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+type MembersModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get returns the membership of a user in a conversation, or ErrRecordNotFound if the user
+// doesn't belong to it.
+func (m MembersModel) Get(conversationId int, userId int64) (*Member, error) {
+	query := `
+		SELECT cm.conversation_id, cm.user_id, u.name, cm.role, cm.joined_at
+		FROM conversation_members cm
+		INNER JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2;
+		`
+	var member Member
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, conversationId, userId).Scan(&member.ConversationId,
+		&member.UserId, &member.Name, &member.Role, &member.JoinedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &member, nil
+}
+
+// GetAll returns every member of a conversation, owner first.
+func (m MembersModel) GetAll(conversationId int) ([]*Member, error) {
+	query := `
+		SELECT cm.conversation_id, cm.user_id, u.name, cm.role, cm.joined_at
+		FROM conversation_members cm
+		INNER JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = $1
+		ORDER BY CASE cm.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, cm.joined_at, cm.user_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var members []*Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.ConversationId, &member.UserId, &member.Name, &member.Role,
+			&member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ConversationIds returns the IDs of the conversations a user belongs to.
+func (m MembersModel) ConversationIds(userId int64) ([]int, error) {
+	query := `
+		SELECT conversation_id FROM conversation_members WHERE user_id = $1;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Count returns the number of members of a conversation.
+func (m MembersModel) Count(conversationId int) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM conversation_members WHERE conversation_id = $1;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, conversationId).Scan(&count)
+	return count, err
+}
+
+// Insert adds a user to a conversation with the role set on the member.
+func (m MembersModel) Insert(member *Member) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO conversation_members (conversation_id, user_id, role)
+			VALUES ($1, $2, $3)
+			RETURNING conversation_id, user_id, role, joined_at
+		)
+		SELECT i.conversation_id, i.user_id, u.name, i.role, i.joined_at
+		FROM inserted i
+		INNER JOIN users u ON u.id = i.user_id;
+		`
+	args := []interface{}{member.ConversationId, member.UserId, member.Role}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&member.ConversationId, &member.UserId,
+		&member.Name, &member.Role, &member.JoinedAt)
+	if err != nil {
+		return membershipError(err)
+	}
+	return nil
+}
+
+// UpdateRole changes the role of an existing non-owner member. Ownership can only be changed
+// through TransferOwnership.
+func (m MembersModel) UpdateRole(member *Member) error {
+	query := `
+		UPDATE conversation_members
+		SET role = $1
+		WHERE conversation_id = $2 AND user_id = $3 AND role <> 'owner'
+		RETURNING role;
+		`
+	args := []interface{}{member.Role, member.ConversationId, member.UserId}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&member.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a non-owner member from a conversation.
+func (m MembersModel) Delete(conversationId int, userId int64) error {
+	query := `
+		DELETE FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2 AND role <> 'owner';
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, conversationId, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// TransferOwnership makes the member newOwnerId the owner of the conversation and demotes the
+// current owner to admin. Both changes happen in a single transaction. ErrRecordNotFound is
+// returned if either user isn't the expected owner or member.
+func (m MembersModel) TransferOwnership(conversationId int, ownerId, newOwnerId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Demote the current owner first, the unique index on owners wouldn't allow two of them.
+	result, err := tx.ExecContext(ctx, `
+		UPDATE conversation_members SET role = 'admin'
+		WHERE conversation_id = $1 AND user_id = $2 AND role = 'owner';`, conversationId, ownerId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		UPDATE conversation_members SET role = 'owner'
+		WHERE conversation_id = $1 AND user_id = $2;`, conversationId, newOwnerId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.In(role, RoleAdmin, RoleMember), "role", "must be either admin or member")
+}
+
+// membershipError translates constraint violations on conversation_members into model errors.
+func membershipError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return ErrDuplicateMember
+		case "23503": // foreign_key_violation
+			return ErrUnknownUser
+		}
+	}
+	return err
+}
@@ -0,0 +1,381 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Mention policies of a conversation, deciding what happens when a message mentions somebody who
+// isn't a member: the mention is either left as plain text or the message is rejected.
+const (
+	MentionPolicyIgnore = "ignore"
+	MentionPolicyReject = "reject"
+)
+
+// MaxMentions is the number of mentions recognised in a single message. Anything past it is left
+// as plain text.
+const MaxMentions = 50
+
+var (
+	// ErrInvalidMention is returned when a message mentions a user outside the conversation and
+	// the conversation's mention policy rejects such messages.
+	ErrInvalidMention = errors.New("mentioned user is not a member")
+
+	// ErrDuplicateHandle is returned when a handle is already taken by another user.
+	ErrDuplicateHandle = errors.New("duplicate handle")
+)
+
+// HandleRX matches valid user handles: a letter followed by 2 to 31 letters, digits or
+// underscores.
+var HandleRX = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{2,31}$`)
+
+// MentionEntity marks a mention of a user in the content of a message. Offset and Length are
+// counted in UTF-16 code units, like string indices in JavaScript, and cover the whole token
+// including the "@".
+type MentionEntity struct {
+	UserId int64 `json:"user_id"`
+	Offset int   `json:"offset"`
+	Length int   `json:"length"`
+}
+
+// MentionEntities are the mentions of a message, stored as JSON in messages.mentions.
+type MentionEntities []MentionEntity
+
+// Scan implements sql.Scanner.
+func (e *MentionEntities) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, e)
+	case string:
+		return json.Unmarshal([]byte(src), e)
+	default:
+		return fmt.Errorf("cannot scan %T into MentionEntities", src)
+	}
+}
+
+// Value implements driver.Valuer.
+func (e MentionEntities) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(e)
+	return string(data), err
+}
+
+// mentionToken is a mention found in message content, by user ID or by handle.
+type mentionToken struct {
+	offset, length int
+	userId         int64
+	handle         string
+}
+
+// parseMentions finds the @mentions in content. "@42" mentions the user with ID 42 and "@alice"
+// the user with the handle alice. An "@" directly following a letter or digit, as in an email
+// address, doesn't start a mention. Whether the users exist isn't checked here.
+func parseMentions(content string) []mentionToken {
+	var tokens []mentionToken
+	offset := 0
+	var prev rune
+	for i := 0; i < len(content) && len(tokens) < MaxMentions; {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if r == '@' && !isMentionRune(prev) {
+			end := i + 1
+			for end < len(content) && isMentionByte(content[end]) {
+				end++
+			}
+			next, _ := utf8.DecodeRuneInString(content[end:])
+			name := content[i+1 : end]
+
+			if name != "" && !isMentionRune(next) {
+				token := mentionToken{offset: offset, length: 1 + len(name)}
+				if id, err := strconv.ParseInt(name, 10, 64); err == nil && id > 0 {
+					token.userId = id
+					tokens = append(tokens, token)
+				} else if HandleRX.MatchString(name) {
+					token.handle = strings.ToLower(name)
+					tokens = append(tokens, token)
+				}
+			}
+		}
+
+		prev = r
+		// Runes outside the Basic Multilingual Plane take two UTF-16 code units.
+		if r >= 0x10000 && r <= utf8.MaxRune {
+			offset += 2
+		} else {
+			offset++
+		}
+		i += size
+	}
+	return tokens
+}
+
+func isMentionByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func isMentionRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// recordMentions resolves the mentions in the content of a message and stores them, replacing
+// the ones recorded before when a message is edited. Mentions of unknown users are left as plain
+// text, mentions of users outside the conversation are either ignored or fail with
+// ErrInvalidMention, depending on the conversation's mention policy. It returns the mention
+// entities and the users mentioned for the first time, who should be notified. The sender is
+// never notified of their own mentions.
+func recordMentions(ctx context.Context, tx *sql.Tx, message *Messages) (MentionEntities, []int64, error) {
+	tokens := parseMentions(message.Content)
+	if message.Type != MessageTypeText {
+		tokens = nil
+	}
+
+	entities := MentionEntities{}
+	mentioned := []int64{}
+	if len(tokens) > 0 {
+		var ids []int64
+		var handles []string
+		for _, token := range tokens {
+			if token.userId != 0 {
+				ids = append(ids, token.userId)
+			} else {
+				handles = append(handles, token.handle)
+			}
+		}
+
+		query := `
+			SELECT u.id, COALESCE(lower(u.handle::text), ''), c.mention_policy, cm.user_id IS NOT NULL
+			FROM users u
+			INNER JOIN user_conversations c ON c.conversation_id = $1
+			LEFT JOIN conversation_members cm ON cm.conversation_id = c.conversation_id AND cm.user_id = u.id
+			WHERE u.id = ANY($2::bigint[]) OR u.handle = ANY($3::citext[]);
+			`
+		rows, err := tx.QueryContext(ctx, query, message.ConversationId, pq.Array(ids), pq.Array(handles))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		members := make(map[int64]bool)
+		byHandle := make(map[string]int64)
+		policy := MentionPolicyIgnore
+		for rows.Next() {
+			var id int64
+			var handle string
+			var member bool
+			if err := rows.Scan(&id, &handle, &policy, &member); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			members[id] = member
+			if handle != "" {
+				byHandle[handle] = id
+			}
+		}
+		// The rows have to be closed before the transaction can run the next statement.
+		if err := rows.Close(); err != nil {
+			return nil, nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		seen := make(map[int64]bool)
+		for _, token := range tokens {
+			id := token.userId
+			if token.handle != "" {
+				id = byHandle[token.handle]
+			}
+
+			member, exists := members[id]
+			switch {
+			case !exists:
+				continue
+			case !member && policy == MentionPolicyReject:
+				return nil, nil, ErrInvalidMention
+			case !member:
+				continue
+			}
+
+			entities = append(entities, MentionEntity{UserId: id, Offset: token.offset, Length: token.length})
+			if id != int64(message.SenderId) && !seen[id] {
+				seen[id] = true
+				mentioned = append(mentioned, id)
+			}
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE messages SET mentions = $2 WHERE message_id = $1;`,
+		message.MessageId, entities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM mentions WHERE message_id = $1 AND NOT (user_id = ANY($2::bigint[]));`,
+		message.MessageId, pq.Array(mentioned))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Users who were already mentioned before an edit keep their read state and aren't notified
+	// again.
+	query := `
+		INSERT INTO mentions (message_id, user_id, conversation_id)
+		SELECT $1, ids.id, $3
+		FROM unnest($2::bigint[]) AS ids (id)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id;
+		`
+	rows, err := tx.QueryContext(ctx, query, message.MessageId, pq.Array(mentioned), message.ConversationId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var notify []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, err
+		}
+		notify = append(notify, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return entities, notify, nil
+}
+
+// Mention is an entry of a user's mentions feed: a message that mentions them, and whether they
+// have seen it yet.
+type Mention struct {
+	Message   *Messages  `json:"message"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+type MentionsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// mentionVisible restricts the mentions "mn" to messages that are still visible to the mentioned
+// user, the member "cm".
+const mentionVisible = `m.deleted_at IS NULL AND ` + notExpired + ` AND ` + notHidden
+
+// GetAll returns a page of the messages mentioning the user, newest first. If unreadOnly is set,
+// mentions already marked as read are left out.
+func (m MentionsModel) GetAll(userId int64, unreadOnly bool, filters Filters) ([]*Mention, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + messageColumns + `, ` + replyCountColumn + `, mn.created_at, mn.read_at
+		FROM mentions mn
+		INNER JOIN messages m ON m.message_id = mn.message_id
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = mn.user_id
+		WHERE mn.user_id = $1 AND (NOT $2::bool OR mn.read_at IS NULL) AND ` + mentionVisible + `
+		ORDER BY mn.created_at DESC, mn.message_id DESC
+		LIMIT $3 OFFSET $4;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userId, unreadOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	mentions := []*Mention{}
+	for rows.Next() {
+		var mention Mention
+		var message Messages
+		dest := append([]interface{}{&totalRecords}, message.scanDest()...)
+		dest = append(dest, &message.ReplyCount, &mention.CreatedAt, &mention.ReadAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, Metadata{}, err
+		}
+		mention.Message = &message
+		mentions = append(mentions, &mention)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return mentions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// UnreadCount returns the number of visible mentions of the user that haven't been read yet.
+func (m MentionsModel) UnreadCount(userId int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM mentions mn
+		INNER JOIN messages m ON m.message_id = mn.message_id
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = mn.user_id
+		WHERE mn.user_id = $1 AND mn.read_at IS NULL AND ` + mentionVisible + `;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks the user's unread mentions as read. A non-zero conversationId limits this to one
+// conversation, and a non-empty messageIds to those messages. It returns the number of mentions
+// that were marked.
+func (m MentionsModel) MarkRead(userId int64, conversationId int, messageIds []int) (int64, error) {
+	query := `
+		UPDATE mentions
+		SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL
+		AND ($2::int = 0 OR conversation_id = $2)
+		AND (cardinality($3::int[]) = 0 OR message_id = ANY($3::int[]));
+		`
+	ids := make([]int64, len(messageIds))
+	for i, id := range messageIds {
+		ids[i] = int64(id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userId, conversationId, pq.Int64Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func ValidateHandle(v *validator.Validator, handle string) {
+	v.Check(handle != "", "handle", "must be provided")
+	v.Check(validator.Matches(handle, HandleRX), "handle",
+		"must start with a letter and contain 3 to 32 letters, digits or underscores")
+}
+
+func ValidateMentionPolicy(v *validator.Validator, policy string) {
+	v.Check(validator.In(policy, MentionPolicyIgnore, MentionPolicyReject), "mention_policy",
+		"must be either ignore or reject")
+}
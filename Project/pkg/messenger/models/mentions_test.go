@@ -0,0 +1,48 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []mentionToken
+	}{
+		{"no mentions here", nil},
+		{"hi @alice!", []mentionToken{{offset: 3, length: 6, handle: "alice"}}},
+		{"@42 ok", []mentionToken{{offset: 0, length: 3, userId: 42}}},
+		{"@Alice_B and @7", []mentionToken{
+			{offset: 0, length: 8, handle: "alice_b"},
+			{offset: 13, length: 2, userId: 7},
+		}},
+		{"(@bob)", []mentionToken{{offset: 1, length: 4, handle: "bob"}}},
+		{"@@bob", []mentionToken{{offset: 1, length: 4, handle: "bob"}}},
+		{"😀 @bob", []mentionToken{{offset: 3, length: 4, handle: "bob"}}},
+		{"mail bob@example.com", nil},
+		{"é@bob", nil},
+		{"@alicé", nil},
+		{"@ab", nil},
+		{"@0", nil},
+		{"@", nil},
+		{"@1abc", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseMentions(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %+v, want %+v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParseMentionsLimit(t *testing.T) {
+	tokens := parseMentions(strings.Repeat("@1 ", MaxMentions+10))
+	if len(tokens) != MaxMentions {
+		t.Fatalf("got %d mentions, want %d", len(tokens), MaxMentions)
+	}
+	if last := tokens[len(tokens)-1]; last.offset != 3*(MaxMentions-1) {
+		t.Errorf("last mention at offset %d, want %d", last.offset, 3*(MaxMentions-1))
+	}
+}
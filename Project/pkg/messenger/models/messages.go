@@ -3,121 +3,674 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/KarenMirzayan/Project/pkg/richtext"
 	"log"
+	"sort"
+	"strconv"
 	"time"
+	"unicode/utf8"
+)
+
+// Message types. System messages are posted by the server itself, e.g. when a conversation
+// setting changes. Poll messages carry their poll as a typed payload.
+const (
+	MessageTypeText   = "text"
+	MessageTypeSystem = "system"
+	MessageTypePoll   = "poll"
 )
 
 type Messages struct {
-	MessageId      string `json:"message_id"`
-	ConversationId string `json:"conversation_id"`
-	SenderId       int    `json:"sender_id"`
-	Content        string `json:"content"`
-	Timestamp      string `json:"timestamp"`
+	MessageId      string     `json:"message_id"`
+	ConversationId string     `json:"conversation_id"`
+	SenderId       int        `json:"sender_id"`
+	Content        string     `json:"content"`
+	Timestamp      string     `json:"timestamp"`
+	Type           string     `json:"type"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ReplyTo        *int       `json:"reply_to_message_id,omitempty"`
+	ReplyCount     int        `json:"reply_count"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	RevisionCount  int        `json:"revision_count"`
+
+	// Seq numbers the messages of a conversation in the order they were sent, without gaps.
+	Seq int64 `json:"seq"`
+
+	// Forwarded copies record the original message, unless its sender turned attribution off,
+	// in which case only Forwarded is set.
+	Forwarded                   bool       `json:"forwarded,omitempty"`
+	ForwardedFromSenderId       *int64     `json:"forwarded_from_sender_id,omitempty"`
+	ForwardedFromConversationId *int       `json:"forwarded_from_conversation_id,omitempty"`
+	ForwardedFromMessageId      *int       `json:"forwarded_from_message_id,omitempty"`
+	ForwardedFromTimestamp      *time.Time `json:"forwarded_from_timestamp,omitempty"`
+
+	// Mentions are the users mentioned in the content. Mentioned lists the users who were
+	// mentioned for the first time by the last Insert or Update and should be notified.
+	Mentions  MentionEntities `json:"mentions,omitempty"`
+	Mentioned []int64         `json:"-"`
+
+	// Entities format the content, RenderedHTML is its sanitized HTML rendering. Both are empty
+	// for unformatted messages.
+	Entities     FormattingEntities `json:"entities,omitempty"`
+	RenderedHTML *string            `json:"rendered_html,omitempty"`
+
+	// Poll is the payload of poll messages, whose content is the question.
+	Poll *Poll `json:"poll,omitempty"`
+
+	// DraftCleared is set by Insert when sending the message discarded the sender's draft.
+	DraftCleared bool `json:"-"`
+
+	// IdempotencyKey is the client's key for a new message, which makes retrying the send safe.
+	// Replayed is set by Insert when the key was used before and the message is the one it sent.
+	IdempotencyKey string `json:"-"`
+	Replayed       bool   `json:"-"`
+
+	// Rank and Snippet are only set on search results. The snippet is HTML with the matching
+	// words wrapped in <mark> tags.
+	Rank    float32 `json:"rank,omitempty"`
+	Snippet string  `json:"snippet,omitempty"`
+
+	// AttachmentIds are the sender's uploaded attachments to link to a new message.
+	AttachmentIds []int64 `json:"-"`
+
+	// Reactions, Attachments and LinkPreview are only filled in by handlers that return messages.
+	// DeliveryStatus is too, but only for the messages of the user the handler returns them to.
+	Reactions      []*ReactionSummary `json:"reactions,omitempty"`
+	Attachments    []*Attachment      `json:"attachments,omitempty"`
+	LinkPreview    *LinkPreview       `json:"link_preview,omitempty"`
+	DeliveryStatus *DeliveryStatus    `json:"delivery_status,omitempty"`
+}
+
+var (
+	// ErrInvalidReply is returned when a message replies to a message that doesn't exist in the
+	// same conversation.
+	ErrInvalidReply = errors.New("invalid reply target")
+
+	// ErrEditWindowClosed is returned when a message is edited after the edit window has passed.
+	ErrEditWindowClosed = errors.New("edit window closed")
+
+	// ErrDeleteWindowClosed is returned when a message is deleted for everyone after the delete
+	// window has passed.
+	ErrDeleteWindowClosed = errors.New("delete window closed")
+)
+
+// MessageRevision is a previous version of a message's content.
+type MessageRevision struct {
+	Revision   int       `json:"revision"`
+	Content    string    `json:"content"`
+	WrittenAt  time.Time `json:"written_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// messageColumns lists the columns scanned by (*Messages).scanDest, for queries where the
+// messages table is aliased as "m".
+const messageColumns = `m.message_id, m.conversation_id, m.sender_id, m.content, m.timestamp, m.type, m.expires_at,
+	m.reply_to_message_id, m.deleted_at, m.edited_at, m.revision_count, m.mentions, m.forwarded,
+	m.forwarded_from_sender_id, m.forwarded_from_conversation_id, m.forwarded_from_message_id,
+	m.forwarded_from_timestamp, m.payload, m.entities, m.rendered_html, m.seq`
+
+// replyCountColumn counts the visible replies to the message "m".
+const replyCountColumn = `(SELECT COUNT(*) FROM messages r
+	WHERE r.reply_to_message_id = m.message_id AND (r.expires_at IS NULL OR r.expires_at > NOW()))`
+
+// notExpired hides messages whose disappearing timer has run out, even if the reaper hasn't
+// deleted them yet.
+const notExpired = `(m.expires_at IS NULL OR m.expires_at > NOW())`
+
+// notHidden hides messages the member "cm" deleted for themselves.
+const notHidden = `NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.message_id AND h.user_id = cm.user_id)`
+
+// scanDest returns the scan destinations matching messageColumns.
+func (message *Messages) scanDest() []interface{} {
+	return []interface{}{&message.MessageId, &message.ConversationId, &message.SenderId, &message.Content,
+		&message.Timestamp, &message.Type, &message.ExpiresAt, &message.ReplyTo, &message.DeletedAt,
+		&message.EditedAt, &message.RevisionCount, &message.Mentions, &message.Forwarded,
+		&message.ForwardedFromSenderId, &message.ForwardedFromConversationId, &message.ForwardedFromMessageId,
+		&message.ForwardedFromTimestamp, pollPayload{&message.Poll}, &message.Entities, &message.RenderedHTML,
+		&message.Seq}
 }
 
 type MessagesModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	// SearchLanguage is the text search configuration new messages are indexed with, e.g.
+	// "english" or "simple". Searches use the configuration each message was indexed with.
+	SearchLanguage string
+	// IdempotencyKeyTTL is how long the idempotency key of a sent message is kept.
+	IdempotencyKeyTTL time.Duration
+}
+
+// CheckSearchLanguage verifies that the configured text search configuration exists.
+func (m MessagesModel) CheckSearchLanguage() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name string
+	err := m.DB.QueryRowContext(ctx, `SELECT $1::regconfig::text;`, m.SearchLanguage).Scan(&name)
+	if err != nil {
+		return fmt.Errorf("text search language %q: %w", m.SearchLanguage, err)
+	}
+	return nil
 }
 
+// Insert adds a message to a conversation the sender is a member of. If the conversation has
+// disappearing messages turned on, the expiry is derived from its message TTL. ErrRecordNotFound
+// is returned if the sender isn't a member of the conversation, ErrInvalidReply if the message
+// replies to a message that isn't in the same conversation, ErrInvalidAttachment if one of the
+// attachments isn't the sender's, and ErrInvalidMention if the conversation rejects mentions of
+// non-members and the message has one. Sending a text message clears the sender's draft. A
+// message with an idempotency key the sender already used in the conversation isn't sent again,
+// messages is filled in with the message sent the first time and Replayed is set instead.
 func (m MessagesModel) Insert(messages *Messages) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if messages.IdempotencyKey != "" {
+		claimed, err := m.claimIdempotencyKey(ctx, tx, messages)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			messages.Replayed = true
+			return nil
+		}
+	}
+
+	if err := m.insert(ctx, tx, messages); err != nil {
+		return err
+	}
+
+	if messages.IdempotencyKey != "" {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE message_idempotency_keys SET message_id = $4
+			WHERE sender_id = $1 AND conversation_id = $2 AND key = $3;`,
+			messages.SenderId, messages.ConversationId, messages.IdempotencyKey, messages.MessageId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// What the sender typed was just sent, so the draft is gone on all of their devices.
+	if messages.Type == MessageTypeText {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM drafts WHERE user_id = $1 AND conversation_id = $2;`, messages.SenderId, messages.ConversationId)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		messages.DraftCleared = rowsAffected > 0
+	}
+
+	return tx.Commit()
+}
+
+// insert does the work of Insert as part of the transaction tx, so that callers can combine
+// sending a message with changes of their own.
+func (m MessagesModel) insert(ctx context.Context, tx *sql.Tx, messages *Messages) error {
+	if messages.Type == "" {
+		messages.Type = MessageTypeText
+	}
+
+	if messages.ReplyTo != nil {
+		if err := checkReplyTarget(ctx, tx, messages.ConversationId, *messages.ReplyTo); err != nil {
+			return err
+		}
+	}
+	messages.render()
+
+	seq, err := nextMessageSeq(ctx, tx, messages.ConversationId)
+	if err != nil {
+		return err
+	}
+
 	// Insert a new menu item into the database.
 	query := `
-		INSERT INTO messages (conversation_id, sender_id, content, timestamp) 
-		VALUES ($1, $2, $3, $4)
-		RETURNING message_id, conversation_id, sender_id, content, timestamp;
+		INSERT INTO messages AS m (conversation_id, sender_id, content, timestamp, type, reply_to_message_id,
+			search_language, forwarded, forwarded_from_sender_id, forwarded_from_conversation_id,
+			forwarded_from_message_id, forwarded_from_timestamp, payload, entities, rendered_html, seq, expires_at)
+		SELECT c.conversation_id, cm.user_id, $3, $4, $5, $6, $7::regconfig, $8, $9, $10, $11, $12, $13, $14, $15, $16,
+			CASE WHEN c.message_ttl > 0 THEN NOW() + make_interval(secs => c.message_ttl) END
+		FROM user_conversations c
+		INNER JOIN conversation_members cm ON cm.conversation_id = c.conversation_id
+		WHERE c.conversation_id = $1 AND cm.user_id = $2
+		RETURNING ` + messageColumns + `;
 		`
-	args := []interface{}{messages.ConversationId, messages.SenderId, messages.Content, messages.Timestamp}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{messages.ConversationId, messages.SenderId, messages.Content, messages.Timestamp, messages.Type,
+		messages.ReplyTo, m.SearchLanguage, messages.Forwarded, messages.ForwardedFromSenderId,
+		messages.ForwardedFromConversationId, messages.ForwardedFromMessageId, messages.ForwardedFromTimestamp,
+		messages.Poll, messages.Entities, messages.RenderedHTML, seq}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(messages.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if err := recordChange(ctx, tx, messages.ConversationId, messages.MessageId, ChangeCreated); err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&messages.MessageId, &messages.ConversationId, &messages.SenderId, &messages.Content, &messages.Timestamp)
+	if err := attachToMessage(ctx, tx, messages.MessageId, messages.SenderId, messages.AttachmentIds); err != nil {
+		return err
+	}
+
+	messages.Mentions, messages.Mentioned, err = recordMentions(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+
+	return queueLinkPreview(ctx, tx, messages)
+}
+
+// checkReplyTarget makes sure that the message being replied to exists, is visible and belongs
+// to the given conversation.
+func checkReplyTarget(ctx context.Context, tx *sql.Tx, conversationID string, replyTo int) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM messages m
+			WHERE m.message_id = $1 AND m.conversation_id = $2 AND m.deleted_at IS NULL AND ` + notExpired + `
+		);
+		`
+	var exists bool
+	if err := tx.QueryRowContext(ctx, query, replyTo, conversationID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInvalidReply
+	}
+	return nil
 }
 
 func (m MessagesModel) Get(conversationID, senderID, messageID string) (*Messages, error) {
 	query := `
-		SELECT m.message_id, m.conversation_id, m.sender_id, m.content, m.timestamp
+		SELECT ` + messageColumns + `, ` + replyCountColumn + `
 		FROM messages m
-		INNER JOIN user_conversations c ON m.conversation_id = c.conversation_id
-		WHERE m.conversation_id = $1 AND m.message_id = $2 AND (c.user_id = $3 OR c.friend_id = $3);
+		INNER JOIN conversation_members cm ON m.conversation_id = cm.conversation_id
+		WHERE m.conversation_id = $1 AND m.message_id = $2 AND cm.user_id = $3 AND ` + notExpired + ` AND ` + notHidden + `;
 	`
 	var messages Messages
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, query, conversationID, messageID, senderID)
-	err := row.Scan(&messages.MessageId, &messages.ConversationId, &messages.SenderId, &messages.Content, &messages.Timestamp)
+	err := row.Scan(append(messages.scanDest(), &messages.ReplyCount)...)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &messages, nil
 }
 
-func (m MessagesModel) Update(messages *Messages) error {
-	// Update a specific menu item in the database.
+// Update replaces the content of a text message written by the sender and keeps the previous
+// content as a revision. Forwarded copies can't be edited. A non-zero editWindow limits how long
+// after sending a message can be edited, ErrEditWindowClosed is returned past it.
+// ErrRecordNotFound is returned if the message doesn't exist, was deleted, or the sender is no
+// longer a member of the conversation, and ErrInvalidMention if the new content mentions a
+// non-member where that isn't allowed.
+func (m MessagesModel) Update(messages *Messages, editWindow time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the message first, so that concurrent edits can't both record the same revision.
 	query := `
-		UPDATE messages m
-		SET content = $1
-		FROM user_conversations c
-		WHERE m.conversation_id = c.conversation_id 
-		AND m.conversation_id = $2 
-		AND m.message_id = $3
-		AND (c.user_id = $4 OR c.friend_id = $4)
-		RETURNING m.message_id, m.conversation_id, m.sender_id, m.content, m.timestamp
-	`
-	args := []interface{}{messages.Content, messages.ConversationId, messages.MessageId, messages.SenderId}
+		SELECT m.content, m.entities, COALESCE(m.edited_at, m.timestamp), m.revision_count,
+			$4::int = 0 OR m.timestamp > NOW() - make_interval(secs => $4::int)
+		FROM messages m
+		INNER JOIN conversation_members cm ON m.conversation_id = cm.conversation_id
+		WHERE m.conversation_id = $1
+		AND m.message_id = $2
+		AND m.sender_id = $3
+		AND cm.user_id = $3
+		AND m.type = 'text'
+		AND NOT m.forwarded
+		AND m.deleted_at IS NULL
+		AND ` + notExpired + `
+		FOR UPDATE OF m;
+		`
+	args := []interface{}{messages.ConversationId, messages.MessageId, messages.SenderId, int(editWindow.Seconds())}
+
+	var (
+		content    string
+		entities   FormattingEntities
+		writtenAt  time.Time
+		revisions  int
+		windowOpen bool
+	)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&content, &entities, &writtenAt, &revisions, &windowOpen)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if !windowOpen {
+		return ErrEditWindowClosed
+	}
+
+	// Saving the same content again isn't an edit, so there is nothing to keep.
+	if content != messages.Content {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO message_revisions (message_id, revision, content, written_at)
+			VALUES ($1, $2, $3, $4);`, messages.MessageId, revisions+1, content, writtenAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE messages
+			SET content = $1, edited_at = NOW(), revision_count = revision_count + 1
+			WHERE message_id = $2;`, messages.Content, messages.MessageId)
+		if err != nil {
+			return err
+		}
+
+		// Bookmarks keep their copy of the message current.
+		_, err = tx.ExecContext(ctx, `
+			UPDATE bookmarks SET content = $1 WHERE message_id = $2;`, messages.Content, messages.MessageId)
+		if err != nil {
+			return err
+		}
+
+		_, messages.Mentioned, err = recordMentions(ctx, tx, messages)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Changing only the formatting doesn't make a revision, but still has to be saved.
+	if content != messages.Content || !entities.equal(messages.Entities) {
+		messages.render()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE messages SET entities = $1, rendered_html = $2 WHERE message_id = $3;`,
+			messages.Entities, messages.RenderedHTML, messages.MessageId)
+		if err != nil {
+			return err
+		}
+
+		// The link to preview may have changed with the content or the link entities.
+		if err := queueLinkPreview(ctx, tx, messages); err != nil {
+			return err
+		}
+
+		if err := recordChange(ctx, tx, messages.ConversationId, messages.MessageId, ChangeEdited); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages m WHERE m.message_id = $1;`,
+		messages.MessageId).Scan(messages.scanDest()...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRevisions returns the previous versions of a message, oldest first. ErrRecordNotFound is
+// returned if the message isn't visible in the conversation. Deleted messages have no history.
+func (m MessagesModel) GetRevisions(conversationId, messageId int) ([]*MessageRevision, error) {
+	query := `
+		SELECT r.revision, r.content, r.written_at, r.replaced_at
+		FROM messages m
+		LEFT JOIN message_revisions r ON r.message_id = m.message_id
+		WHERE m.conversation_id = $1 AND m.message_id = $2 AND m.deleted_at IS NULL AND ` + notExpired + `
+		ORDER BY r.revision;
+		`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, query, args...)
-	err := row.Scan(&messages.MessageId, &messages.ConversationId, &messages.SenderId, &messages.Content, &messages.Timestamp)
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	found := false
+	revisions := []*MessageRevision{}
+	for rows.Next() {
+		found = true
+
+		// The left join yields a single row of NULLs for a message that was never edited.
+		var (
+			revision              sql.NullInt64
+			content               sql.NullString
+			writtenAt, replacedAt sql.NullTime
+		)
+		if err := rows.Scan(&revision, &content, &writtenAt, &replacedAt); err != nil {
+			return nil, err
+		}
+		if !revision.Valid {
+			continue
+		}
+		revisions = append(revisions, &MessageRevision{
+			Revision:   int(revision.Int64),
+			Content:    content.String,
+			WrittenAt:  writtenAt.Time,
+			ReplacedAt: replacedAt.Time,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return revisions, nil
+}
+
+// DeleteForEveryone replaces a text message or poll written by the sender with a tombstone. A
+// non-zero deleteWindow limits how long after sending this is allowed, ErrDeleteWindowClosed is
+// returned past it. ErrRecordNotFound is returned if there is no such message or it was already
+// deleted.
+func (m MessagesModel) DeleteForEveryone(conversationID, senderID, messageID string, deleteWindow time.Duration) error {
+	return m.tombstone(deleteWindow, `m.conversation_id = $2 AND m.message_id = $3 AND m.sender_id = $4 AND m.type IN ('text', 'poll')`,
+		conversationID, messageID, senderID)
+}
+
+// DeleteAny replaces any message of the conversation with a tombstone. It is used by conversation
+// admins to moderate the conversation, so no time limit applies.
+func (m MessagesModel) DeleteAny(conversationID, messageID string) error {
+	return m.tombstone(0, `m.conversation_id = $2 AND m.message_id = $3`, conversationID, messageID)
+}
+
+// tombstone clears the content of the message matching the condition and marks it as deleted.
+// The row itself stays, so pagination and reply threads keep working, but its edit history,
+// reactions, attachments, mentions, pin, poll votes, link preview and receipts go with the
+// content. The condition refers to the message as "m" and its parameters start at $2, $1 being the
+// time window in seconds.
+func (m MessagesModel) tombstone(window time.Duration, condition string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	query := `
+		SELECT m.message_id, m.conversation_id, $1::int = 0 OR m.timestamp > NOW() - make_interval(secs => $1::int)
+		FROM messages m
+		WHERE ` + condition + ` AND m.deleted_at IS NULL AND ` + notExpired + `
+		FOR UPDATE OF m;
+		`
+	args = append([]interface{}{int(window.Seconds())}, args...)
+
+	var messageID, conversationID string
+	var windowOpen bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&messageID, &conversationID, &windowOpen)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if !windowOpen {
+		return ErrDeleteWindowClosed
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE messages
+		SET content = '', deleted_at = NOW(), edited_at = NULL, revision_count = 0, mentions = '[]', payload = NULL,
+			entities = '[]', rendered_html = NULL
+		WHERE message_id = $1;`, messageID)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM message_revisions WHERE message_id = $1;`,
+		`DELETE FROM message_reactions WHERE message_id = $1;`,
+		`DELETE FROM message_attachments WHERE message_id = $1;`,
+		`DELETE FROM mentions WHERE message_id = $1;`,
+		`DELETE FROM pinned_messages WHERE message_id = $1;`,
+		`DELETE FROM poll_votes WHERE message_id = $1;`,
+		`DELETE FROM message_link_previews WHERE message_id = $1;`,
+		`DELETE FROM message_receipts WHERE message_id = $1;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, messageID); err != nil {
+			return err
+		}
+	}
+
+	if err := recordChange(ctx, tx, conversationID, messageID, ChangeDeleted); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m MessagesModel) Delete(conversationID, senderID, messageID string) error {
-	// Delete a specific menu item from the database.
+// DeleteForMe hides a message from the given user only, everybody else still sees it. The other
+// devices of the user learn about it when they sync. ErrRecordNotFound is returned if the message
+// isn't visible to the user or is already hidden.
+func (m MessagesModel) DeleteForMe(conversationID, userID, messageID string) error {
 	query := `
-		DELETE FROM messages
-		WHERE conversation_id = $1 AND sender_id = $2 AND message_id = $3;
-	`
+		INSERT INTO message_hidden (message_id, user_id)
+		SELECT m.message_id, cm.user_id
+		FROM messages m
+		INNER JOIN conversation_members cm ON m.conversation_id = cm.conversation_id
+		WHERE m.conversation_id = $1 AND m.message_id = $2 AND cm.user_id = $3 AND ` + notExpired + `
+		ON CONFLICT DO NOTHING
+		RETURNING user_id;
+		`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, conversationID, senderID, messageID)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hiddenFor int64
+	err = tx.QueryRowContext(ctx, query, conversationID, messageID, userID).Scan(&hiddenFor)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if err := recordUserChange(ctx, tx, conversationID, messageID, hiddenFor, ChangeDeleted); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func ValidateMessage(v *validator.Validator, message *Messages) {
-	// Check if the content field is empty.
-	v.Check(message.Content != "", "content", "must be provided")
-	// Add additional validation rules as needed.
-}
+	// Check if the content field is empty. Attachments can be sent without any text.
+	v.Check(message.Content != "" || len(message.AttachmentIds) > 0, "content", "must be provided")
+	v.Check(utf8.RuneCountInString(message.Content) <= MaxMessageLength, "content",
+		fmt.Sprintf("must not be more than %d characters long", MaxMessageLength))
 
-func (m MessagesModel) GetAll(userId, conversationId int, query string, filters Filters) ([]*Messages, Metadata, error) {
-	// Construct the SQL query for retrieving messages with content containing the query string.
-	// We use the ILIKE operator for case-insensitive search.
-	// We also use the OFFSET and LIMIT clauses for pagination.
-	sqlQuery := fmt.Sprintf(`
-        SELECT count(*) OVER(), m.message_id, m.conversation_id, m.sender_id, m.content, m.timestamp
-        FROM messages m
-        INNER JOIN user_conversations c ON m.conversation_id = c.conversation_id
-        WHERE m.content ILIKE '%%%s%%' AND m.conversation_id = $1
-        AND (c.user_id = $2 OR c.friend_id = $2)
-        ORDER BY %s %s
-        LIMIT $3 OFFSET $4`, query, filters.sortColumn(), filters.sortDirection())
+	// The formatting has to fit the content it formats.
+	if err := richtext.Validate(message.Content, message.Entities); err != nil {
+		v.AddError("entities", err.Error())
+	}
+}
 
+// GetAll returns a page of the messages of a conversation the user belongs to. If search is not
+// empty, only messages matching it are returned, see ParseSearchQuery for the syntax, with their
+// rank and a highlighted snippet. Only the sort column and direction, which are checked against
+// the safelist, are spliced into the query.
+func (m MessagesModel) GetAll(userId, conversationId int, search string, filters Filters) ([]*Messages, Metadata, error) {
 	// Create a context with a timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	args := []interface{}{conversationId, userId, filters.limit(), filters.offset()}
+	match, rank, snippet := "TRUE", "0::real", "''"
+	if search != "" {
+		tsquery, err := ParseSearchQuery(search)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Each message is searched in the language it was indexed with, which is the configured
+		// one at the time it was sent.
+		languages, err := searchLanguages(ctx, m.DB,
+			`SELECT DISTINCT search_language::text FROM messages WHERE conversation_id = $1;`, conversationId)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		args = append(args, tsquery, headlineOptions)
+		match = searchMatch("m", languages, "$5")
+		rank = searchRank("m", languages, "$5")
+		snippet = searchSnippet("m", languages, "$5", "$6")
+	}
+
+	sqlQuery := fmt.Sprintf(`
+        SELECT count(*) OVER(), `+messageColumns+`, `+replyCountColumn+`, %s AS rank, %s AS snippet
+        FROM messages m
+        INNER JOIN conversation_members cm ON m.conversation_id = cm.conversation_id
+        WHERE m.conversation_id = $1
+        AND cm.user_id = $2
+        AND %s
+        AND `+notExpired+`
+        AND `+notHidden+`
+        ORDER BY %s %s, m.message_id %[5]s
+        LIMIT $3 OFFSET $4`, rank, snippet, match, filters.sortColumn(), filters.sortDirection())
+
 	// Execute the query and retrieve the result set.
-	rows, err := m.DB.QueryContext(ctx, sqlQuery, conversationId, userId, filters.limit(), filters.offset())
+	rows, err := m.DB.QueryContext(ctx, sqlQuery, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -134,9 +687,13 @@ func (m MessagesModel) GetAll(userId, conversationId int, query string, filters
 	// Iterate over the result set and scan each row into a Message struct.
 	for rows.Next() {
 		var message Messages
-		if err := rows.Scan(&totalRecords, &message.MessageId, &message.ConversationId, &message.SenderId, &message.Content, &message.Timestamp); err != nil {
+		dest := append([]interface{}{&totalRecords}, message.scanDest()...)
+		if err := rows.Scan(append(dest, &message.ReplyCount, &message.Rank, &message.Snippet)...); err != nil {
 			return nil, Metadata{}, err
 		}
+		if message.Snippet != "" {
+			message.Snippet = highlightSnippet(message.Snippet)
+		}
 		messages = append(messages, &message)
 	}
 
@@ -151,3 +708,189 @@ func (m MessagesModel) GetAll(userId, conversationId int, query string, filters
 	// Return the messages and metadata.
 	return messages, metadata, nil
 }
+
+// GetReplies returns a page of the replies to a message, oldest first. The user must be a member
+// of the conversation.
+func (m MessagesModel) GetReplies(userId, conversationId, messageId int, filters Filters) ([]*Messages, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + messageColumns + `, ` + replyCountColumn + `
+		FROM messages m
+		INNER JOIN conversation_members cm ON m.conversation_id = cm.conversation_id
+		WHERE m.conversation_id = $1 AND m.reply_to_message_id = $2 AND cm.user_id = $3
+		AND ` + notExpired + ` AND ` + notHidden + `
+		ORDER BY m.timestamp, m.message_id
+		LIMIT $4 OFFSET $5;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, messageId, userId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	var replies []*Messages
+	for rows.Next() {
+		var message Messages
+		dest := append([]interface{}{&totalRecords}, message.scanDest()...)
+		if err := rows.Scan(append(dest, &message.ReplyCount)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		replies = append(replies, &message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return replies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ExpiredMessage identifies a message removed by DeleteExpired.
+type ExpiredMessage struct {
+	MessageId      int
+	ConversationId int
+}
+
+// DeleteExpired deletes up to limit messages whose expiry has passed and returns what was
+// deleted. Rows locked by a concurrent reaper are skipped, so several instances can run it at the
+// same time.
+func (m MessagesModel) DeleteExpired(limit int) ([]ExpiredMessage, error) {
+	query := `
+		DELETE FROM messages
+		WHERE message_id IN (
+			SELECT message_id
+			FROM messages
+			WHERE expires_at <= NOW()
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING message_id, conversation_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []ExpiredMessage
+	for rows.Next() {
+		var message ExpiredMessage
+		if err := rows.Scan(&message.MessageId, &message.ConversationId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted = append(deleted, message)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	// Syncing clients learn about the deletions from the change log. Conversations are locked in
+	// order, so that concurrent reapers can't deadlock on them.
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].ConversationId != deleted[j].ConversationId {
+			return deleted[i].ConversationId < deleted[j].ConversationId
+		}
+		return deleted[i].MessageId < deleted[j].MessageId
+	})
+	for _, message := range deleted {
+		err := recordChange(ctx, tx, strconv.Itoa(message.ConversationId), strconv.Itoa(message.MessageId), ChangeDeleted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// ExportedMessage is a message together with the name of its sender, as written to exports.
+type ExportedMessage struct {
+	Messages
+	SenderName string `json:"sender_name"`
+}
+
+// exportBatchSize is the number of rows fetched from the export cursor at a time.
+const exportBatchSize = 500
+
+// Export calls fn for every visible message of a conversation in chronological order. Rows are
+// read from a server-side cursor in batches, so memory use doesn't grow with the size of the
+// conversation. The export runs in a read-only snapshot, and stops at the first error returned
+// by fn. The caller controls how long it may take through ctx.
+//
+// A member's export leaves out the messages userId deleted for themselves. A userId of 0 exports
+// the messages as every member sees them, which is what staff exports do.
+func (m MessagesModel) Export(ctx context.Context, conversationId int, userId int64, fn func(*ExportedMessage) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DECLARE export_cursor NO SCROLL CURSOR FOR
+		SELECT `+messageColumns+`, COALESCE(u.name, '')
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.sender_id
+		WHERE m.conversation_id = $1 AND `+notExpired+`
+		AND ($2::bigint = 0 OR NOT EXISTS (
+			SELECT 1 FROM message_hidden h WHERE h.message_id = m.message_id AND h.user_id = $2))
+		ORDER BY m.timestamp, m.message_id;`, conversationId, userId)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM export_cursor;", exportBatchSize))
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for rows.Next() {
+			var message ExportedMessage
+			if err := rows.Scan(append(message.scanDest(), &message.SenderName)...); err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+
+			if err := fn(&message); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		if n < exportBatchSize {
+			return nil
+		}
+	}
+}
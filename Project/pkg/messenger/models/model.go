@@ -22,6 +22,19 @@ type Models struct {
 	Channels      ChannelsModel
 	Tokens        TokenModel
 	Permissions   PermissionModel
+	Members       MembersModel
+	Invites       InvitesModel
+	Reactions     ReactionsModel
+	Attachments   AttachmentsModel
+	Uploads       UploadsModel
+	Mentions      MentionsModel
+	Scheduled     ScheduledMessagesModel
+	Pins          PinsModel
+	Bookmarks     BookmarksModel
+	Drafts        DraftsModel
+	Polls         PollsModel
+	LinkPreviews  LinkPreviewsModel
+	Receipts      ReceiptsModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -39,9 +52,10 @@ func NewModels(db *sql.DB) Models {
 			ErrorLog: errorLog,
 		},
 		Messages: MessagesModel{
-			DB:       db,
-			InfoLog:  infoLog,
-			ErrorLog: errorLog,
+			DB:             db,
+			InfoLog:        infoLog,
+			ErrorLog:       errorLog,
+			SearchLanguage: "english",
 		},
 		Channels: ChannelsModel{
 			DB:       db,
@@ -58,5 +72,70 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Members: MembersModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Invites: InvitesModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reactions: ReactionsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Attachments: AttachmentsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Uploads: UploadsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Mentions: MentionsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Scheduled: ScheduledMessagesModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Pins: PinsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Bookmarks: BookmarksModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Drafts: DraftsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Polls: PollsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		LinkPreviews: LinkPreviewsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Receipts: ReceiptsModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 	}
 }
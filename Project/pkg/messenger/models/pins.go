@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// ErrTooManyPins is returned when pinning a message would exceed the conversation's cap.
+	ErrTooManyPins = errors.New("too many pinned messages")
+)
+
+// PinnedMessage is a message pinned in a conversation. PinnedBy is nil if the user who pinned it
+// was deleted.
+type PinnedMessage struct {
+	Message  *Messages `json:"message"`
+	PinnedBy *int64    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// pinnedCountColumn counts the pins of the conversation "c" whose messages haven't expired.
+const pinnedCountColumn = `(SELECT COUNT(*) FROM pinned_messages p
+	INNER JOIN messages m ON m.message_id = p.message_id
+	WHERE p.conversation_id = c.conversation_id AND ` + notExpired + `)`
+
+type PinsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Pin pins a message of a conversation on behalf of a user and reports whether it wasn't pinned
+// already. ErrRecordNotFound is returned if the message isn't visible in the conversation, and
+// ErrTooManyPins if the conversation already has maxPins pinned messages. If the message gets
+// pinned and notice isn't nil, it is sent to the conversation in the same transaction.
+func (m PinsModel) Pin(conversationId, messageId int, userId int64, maxPins int, messages MessagesModel, notice *Messages) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the conversation so that concurrent pins can't both slip under the cap. Pins of expired
+	// messages don't count, like in the pinned count shown with the conversation.
+	var pins int
+	var existing bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			`+pinnedCountColumn+`,
+			EXISTS (SELECT 1 FROM pinned_messages p WHERE p.conversation_id = c.conversation_id AND p.message_id = m.message_id)
+		FROM user_conversations c
+		INNER JOIN messages m ON m.conversation_id = c.conversation_id
+		WHERE c.conversation_id = $1 AND m.message_id = $2 AND m.deleted_at IS NULL AND `+notExpired+`
+		FOR UPDATE OF c;`, conversationId, messageId).Scan(&pins, &existing)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	if existing {
+		return false, nil
+	}
+	if pins >= maxPins {
+		return false, ErrTooManyPins
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pinned_messages (conversation_id, message_id, pinned_by)
+		VALUES ($1, $2, $3);`, conversationId, messageId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	if notice != nil {
+		if err := messages.insert(ctx, tx, notice); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// Unpin removes a pin. ErrRecordNotFound is returned if the message isn't pinned.
+func (m PinsModel) Unpin(conversationId, messageId int) error {
+	query := `
+		DELETE FROM pinned_messages
+		WHERE conversation_id = $1 AND message_id = $2;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, conversationId, messageId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns the pinned messages of a conversation visible to the user, in the order they
+// were pinned.
+func (m PinsModel) GetAll(userId int64, conversationId int) ([]*PinnedMessage, error) {
+	query := `
+		SELECT ` + messageColumns + `, ` + replyCountColumn + `, p.pinned_by, p.pinned_at
+		FROM pinned_messages p
+		INNER JOIN messages m ON m.message_id = p.message_id
+		INNER JOIN conversation_members cm ON cm.conversation_id = p.conversation_id
+		WHERE p.conversation_id = $1 AND cm.user_id = $2 AND ` + notExpired + ` AND ` + notHidden + `
+		ORDER BY p.pinned_at, p.message_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	pins := []*PinnedMessage{}
+	for rows.Next() {
+		var pin PinnedMessage
+		var message Messages
+		dest := append(message.scanDest(), &message.ReplyCount, &pin.PinnedBy, &pin.PinnedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		pin.Message = &message
+		pins = append(pins, &pin)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
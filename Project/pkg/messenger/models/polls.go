@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Limits on the shape of a poll.
+const (
+	MinPollOptions      = 2
+	MaxPollOptions      = 10
+	MaxPollQuestion     = 300
+	MaxPollOptionLength = 100
+)
+
+var (
+	// ErrPollClosed is returned when voting on a poll whose close time has passed.
+	ErrPollClosed = errors.New("poll closed")
+
+	// ErrInvalidVote is returned when a vote names options the poll doesn't have, or more than
+	// one option of a single choice poll.
+	ErrInvalidVote = errors.New("invalid vote")
+)
+
+// Poll is the payload of a poll message. Options are numbered from 1 in the order they were given.
+// Results are not stored with the poll, they are filled in by handlers that return messages.
+type Poll struct {
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	Results        *PollResults `json:"results,omitempty"`
+}
+
+type PollOption struct {
+	Id   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+// PollResults are the aggregated votes of a poll as seen by one user. Voters are only listed for
+// polls that aren't anonymous, MyVotes are the options the user picked.
+type PollResults struct {
+	Closed      bool                `json:"closed"`
+	TotalVoters int                 `json:"total_voters"`
+	Options     []*PollOptionResult `json:"options"`
+	MyVotes     []int               `json:"my_votes"`
+}
+
+type PollOptionResult struct {
+	OptionId int     `json:"option_id"`
+	Votes    int     `json:"votes"`
+	Voters   []int64 `json:"voters,omitempty"`
+}
+
+// NewPoll returns a poll with the given options numbered in order.
+func NewPoll(question string, options []string, multipleChoice, anonymous bool, closesAt *time.Time) *Poll {
+	poll := &Poll{
+		Question:       strings.TrimSpace(question),
+		Options:        make([]PollOption, len(options)),
+		MultipleChoice: multipleChoice,
+		Anonymous:      anonymous,
+		ClosesAt:       closesAt,
+	}
+	for i, option := range options {
+		poll.Options[i] = PollOption{Id: i + 1, Text: strings.TrimSpace(option)}
+	}
+	return poll
+}
+
+// Closed reports whether the poll no longer accepts votes at the given time.
+func (p *Poll) Closed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
+
+// Value implements driver.Valuer. Results aren't part of the stored payload.
+func (p Poll) Value() (driver.Value, error) {
+	p.Results = nil
+	data, err := json.Marshal(p)
+	return string(data), err
+}
+
+// pollPayload scans the payload column into a message's poll, leaving it nil for messages that
+// have no payload.
+type pollPayload struct {
+	poll **Poll
+}
+
+// Scan implements sql.Scanner.
+func (p pollPayload) Scan(src interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		*p.poll = nil
+		return nil
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into Poll", src)
+	}
+
+	var poll Poll
+	if err := json.Unmarshal(data, &poll); err != nil {
+		return err
+	}
+	*p.poll = &poll
+	return nil
+}
+
+type PollsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Vote replaces the user's vote on a poll of the conversation with the given options. An empty
+// list takes the vote back. ErrRecordNotFound is returned if the poll isn't visible to the user,
+// ErrPollClosed if it is closed and ErrInvalidVote if the options don't fit the poll.
+func (m PollsModel) Vote(userId int64, conversationId, messageId int, optionIds []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the membership serializes the votes of the user, so that two devices voting at
+	// once can't end up with both of their choices on a single choice poll.
+	query := `
+		SELECT m.payload
+		FROM messages m
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		WHERE m.conversation_id = $1 AND m.message_id = $2 AND cm.user_id = $3 AND m.type = 'poll'
+		AND m.deleted_at IS NULL AND ` + notExpired + ` AND ` + notHidden + `
+		FOR UPDATE OF cm;
+		`
+	var poll *Poll
+	err = tx.QueryRowContext(ctx, query, conversationId, messageId, userId).Scan(pollPayload{&poll})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if poll == nil {
+		return ErrRecordNotFound
+	}
+	if poll.Closed(time.Now()) {
+		return ErrPollClosed
+	}
+	if len(optionIds) > 1 && !poll.MultipleChoice {
+		return ErrInvalidVote
+	}
+	for _, id := range optionIds {
+		if id < 1 || id > len(poll.Options) {
+			return ErrInvalidVote
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM poll_votes WHERE message_id = $1 AND user_id = $2;`, messageId, userId)
+	if err != nil {
+		return err
+	}
+
+	if len(optionIds) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO poll_votes (message_id, user_id, option_id)
+			SELECT $1, $2, unnest($3::int[]);`, messageId, userId, pq.Array(optionIds))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadResults fills in the results of the poll messages among messages, as seen by the user,
+// using a single query for all of them.
+func (m PollsModel) LoadResults(userId int64, messages []*Messages) error {
+	polls := make(map[string]*Poll)
+	var ids []string
+	for _, message := range messages {
+		if message.Poll == nil {
+			continue
+		}
+		results := &PollResults{
+			Closed:  message.Poll.Closed(time.Now()),
+			Options: make([]*PollOptionResult, len(message.Poll.Options)),
+			MyVotes: []int{},
+		}
+		for i, option := range message.Poll.Options {
+			results.Options[i] = &PollOptionResult{OptionId: option.Id}
+		}
+		message.Poll.Results = results
+		polls[message.MessageId] = message.Poll
+		ids = append(ids, message.MessageId)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT message_id, user_id, option_id
+		FROM poll_votes
+		WHERE message_id = ANY($1::int[])
+		ORDER BY voted_at, user_id, option_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	voters := make(map[string]map[int64]bool)
+	for rows.Next() {
+		var messageId int
+		var voterId int64
+		var optionId int
+		if err := rows.Scan(&messageId, &voterId, &optionId); err != nil {
+			return err
+		}
+
+		key := strconv.Itoa(messageId)
+		poll := polls[key]
+		if optionId < 1 || optionId > len(poll.Results.Options) {
+			continue
+		}
+
+		option := poll.Results.Options[optionId-1]
+		option.Votes++
+		if !poll.Anonymous {
+			option.Voters = append(option.Voters, voterId)
+		}
+		if voterId == userId {
+			poll.Results.MyVotes = append(poll.Results.MyVotes, optionId)
+		}
+
+		if voters[key] == nil {
+			voters[key] = make(map[int64]bool)
+		}
+		voters[key][voterId] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for key, poll := range polls {
+		poll.Results.TotalVoters = len(voters[key])
+	}
+	return nil
+}
+
+func ValidatePoll(v *validator.Validator, poll *Poll, now time.Time) {
+	v.Check(poll.Question != "", "question", "must be provided")
+	v.Check(utf8.RuneCountInString(poll.Question) <= MaxPollQuestion, "question",
+		fmt.Sprintf("must not be more than %d characters long", MaxPollQuestion))
+
+	v.Check(len(poll.Options) >= MinPollOptions, "options", fmt.Sprintf("must contain at least %d options", MinPollOptions))
+	v.Check(len(poll.Options) <= MaxPollOptions, "options", fmt.Sprintf("must not contain more than %d options", MaxPollOptions))
+	texts := make([]string, len(poll.Options))
+	for i, option := range poll.Options {
+		texts[i] = option.Text
+		if option.Text == "" || utf8.RuneCountInString(option.Text) > MaxPollOptionLength {
+			v.AddError("options", fmt.Sprintf("must only contain options of 1 to %d characters", MaxPollOptionLength))
+			break
+		}
+	}
+	v.Check(validator.Unique(texts), "options", "must not contain duplicate values")
+
+	if poll.ClosesAt != nil {
+		v.Check(poll.ClosesAt.After(now), "closes_at", "must be in the future")
+		v.Check(poll.ClosesAt.Before(now.Add(MaxScheduleAhead)), "closes_at", "must not be more than a year ahead")
+	}
+}
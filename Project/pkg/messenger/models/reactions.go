@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// MaxDistinctReactions is the number of different emoji a single message can be reacted with.
+const MaxDistinctReactions = 20
+
+var (
+	// ErrTooManyReactions is returned when a new emoji would exceed MaxDistinctReactions.
+	ErrTooManyReactions = errors.New("too many distinct reactions")
+)
+
+// keycapRX matches keycap emoji such as 1️⃣, the only emoji that start with an ASCII character.
+var keycapRX = regexp.MustCompile("[0-9#*]\uFE0F?\u20E3")
+
+// ReactionSummary is the aggregated view of one emoji on a message.
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// Reactor is a user who reacted to a message with a particular emoji.
+type Reactor struct {
+	UserId    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReactionsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Add reacts to a message on behalf of a user. Reacting twice with the same emoji is a no-op.
+// ErrRecordNotFound is returned if the message isn't visible in the conversation, and
+// ErrTooManyReactions if the emoji would be one too many on the message.
+func (m ReactionsModel) Add(conversationId, messageId int, userId int64, emoji string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the message so that concurrent reactions can't both slip under the cap.
+	var distinct int
+	var existing bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT r.emoji) FROM message_reactions r WHERE r.message_id = m.message_id),
+			EXISTS (SELECT 1 FROM message_reactions r WHERE r.message_id = m.message_id AND r.emoji = $3)
+		FROM messages m
+		WHERE m.message_id = $1 AND m.conversation_id = $2 AND m.deleted_at IS NULL AND `+notExpired+`
+		FOR UPDATE OF m;`, messageId, conversationId, emoji).Scan(&distinct, &existing)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if !existing && distinct >= MaxDistinctReactions {
+		return ErrTooManyReactions
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING;`, messageId, userId, emoji)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Remove takes back a user's reaction. ErrRecordNotFound is returned if the user hadn't reacted
+// to the message with that emoji.
+func (m ReactionsModel) Remove(conversationId, messageId int, userId int64, emoji string) error {
+	query := `
+		DELETE FROM message_reactions r
+		USING messages m
+		WHERE r.message_id = m.message_id AND m.conversation_id = $1
+		AND r.message_id = $2 AND r.user_id = $3 AND r.emoji = $4;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, conversationId, messageId, userId, emoji)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Summaries returns the aggregated reactions for a set of messages in a single query, keyed by
+// message ID. Emoji are ordered by how often they were used, then by when they were first used.
+func (m ReactionsModel) Summaries(userId int, messageIds []string) (map[string][]*ReactionSummary, error) {
+	summaries := make(map[string][]*ReactionSummary)
+	if len(messageIds) == 0 {
+		return summaries, nil
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*), bool_or(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1::int[])
+		GROUP BY message_id, emoji
+		ORDER BY message_id, COUNT(*) DESC, MIN(created_at), emoji;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(messageIds), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var messageId string
+		var summary ReactionSummary
+		if err := rows.Scan(&messageId, &summary.Emoji, &summary.Count, &summary.Reacted); err != nil {
+			return nil, err
+		}
+		summaries[messageId] = append(summaries[messageId], &summary)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// GetReactors returns a page of the users who reacted to a message with the given emoji, in the
+// order they reacted.
+func (m ReactionsModel) GetReactors(conversationId, messageId int, emoji string, filters Filters) ([]*Reactor, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), u.id, u.name, r.created_at
+		FROM message_reactions r
+		INNER JOIN messages m ON m.message_id = r.message_id
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE m.conversation_id = $1 AND r.message_id = $2 AND r.emoji = $3
+		ORDER BY r.created_at, u.id
+		LIMIT $4 OFFSET $5;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, messageId, emoji, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	var reactors []*Reactor
+	for rows.Next() {
+		var reactor Reactor
+		if err := rows.Scan(&totalRecords, &reactor.UserId, &reactor.Name, &reactor.CreatedAt); err != nil {
+			return nil, Metadata{}, err
+		}
+		reactors = append(reactors, &reactor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reactors, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ValidateEmoji checks that a reaction is a short run of non-text characters. It doesn't try to
+// recognise every emoji sequence, it only keeps out words, whitespace and oversized values.
+// Keycaps are allowed even though they start with a digit, # or *.
+func ValidateEmoji(v *validator.Validator, emoji string) {
+	v.Check(emoji != "", "emoji", "must be provided")
+	v.Check(utf8.ValidString(emoji), "emoji", "must be valid UTF-8")
+	v.Check(len(emoji) <= 32, "emoji", "must not be more than 32 bytes long")
+	v.Check(!strings.ContainsFunc(keycapRX.ReplaceAllString(emoji, ""), func(r rune) bool {
+		return r < utf8.RuneSelf || unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r)
+	}), "emoji", "must be an emoji")
+}
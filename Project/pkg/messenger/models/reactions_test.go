@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+)
+
+func TestValidateEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👨‍👩‍👧", true},
+		{"🇦🇲", true},
+		{"1️⃣", true},
+		{"#⃣", true},
+		{"*️⃣", true},
+		{"", false},
+		{"1", false},
+		{"1️", false},
+		{"a⃣", false},
+		{"ok", false},
+		{"👍 ", false},
+		{"\xff", false},
+		{"👍👍👍👍👍👍👍👍👍", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateEmoji(v, tt.emoji)
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidateEmoji(%q): valid = %v, want %v (%v)", tt.emoji, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Delivery states of a message, for one recipient or for all of them together.
+const (
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryRead      = "read"
+)
+
+// MaxReceiptBatch is the number of messages a client may acknowledge at once.
+const MaxReceiptBatch = 500
+
+// Receipt is the delivery state of a message for one recipient.
+type Receipt struct {
+	UserId      int64      `json:"user_id"`
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// AckedReceipt is a receipt that changed because of an acknowledgement, with what is needed to
+// tell the sender about it.
+type AckedReceipt struct {
+	MessageId      int        `json:"message_id"`
+	ConversationId int        `json:"-"`
+	SenderId       int64      `json:"-"`
+	UserId         int64      `json:"user_id"`
+	DeliveredAt    time.Time  `json:"delivered_at"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+// DeliveryStatus is the delivery state of a message across its recipients, who are the current
+// members of the conversation other than the sender. A message is delivered once every recipient
+// acknowledged it and read once every recipient read it. A message without recipients stays sent.
+type DeliveryStatus struct {
+	Status     string `json:"status"`
+	Recipients int    `json:"recipients"`
+	Delivered  int    `json:"delivered"`
+	Read       int    `json:"read"`
+}
+
+func newDeliveryStatus(recipients, delivered, read int) *DeliveryStatus {
+	status := &DeliveryStatus{Status: DeliverySent, Recipients: recipients, Delivered: delivered, Read: read}
+	switch {
+	case recipients == 0:
+	case read == recipients:
+		status.Status = DeliveryRead
+	case delivered == recipients:
+		status.Status = DeliveryDelivered
+	}
+	return status
+}
+
+type ReceiptsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Ack records that the user's device received the delivered messages and showed the read ones.
+// Messages the user can't see and their own messages are skipped. The receipts that changed are
+// returned, acknowledging the same state again changes nothing.
+func (m ReceiptsModel) Ack(userId int64, delivered, read []int) ([]*AckedReceipt, error) {
+	// The insert doesn't see the rows it inserts, so the messages are joined again afterwards.
+	query := `
+		WITH acked AS (
+			INSERT INTO message_receipts AS r (message_id, user_id, delivered_at, read_at)
+			SELECT m.message_id, cm.user_id, NOW(), CASE WHEN m.message_id = ANY($3::int[]) THEN NOW() END
+			FROM messages m
+			INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $1
+			WHERE (m.message_id = ANY($2::int[]) OR m.message_id = ANY($3::int[])) AND m.sender_id <> $1
+			AND m.deleted_at IS NULL AND ` + notExpired + ` AND ` + notHidden + `
+			ON CONFLICT (message_id, user_id) DO UPDATE
+			SET read_at = EXCLUDED.read_at
+			WHERE r.read_at IS NULL AND EXCLUDED.read_at IS NOT NULL
+			RETURNING r.message_id, r.user_id, r.delivered_at, r.read_at
+		)
+		SELECT a.message_id, m.conversation_id, m.sender_id, a.user_id, a.delivered_at, a.read_at
+		FROM acked a
+		INNER JOIN messages m ON m.message_id = a.message_id
+		ORDER BY a.message_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userId, pq.Array(delivered), pq.Array(read))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	acked := []*AckedReceipt{}
+	for rows.Next() {
+		var receipt AckedReceipt
+		err := rows.Scan(&receipt.MessageId, &receipt.ConversationId, &receipt.SenderId, &receipt.UserId,
+			&receipt.DeliveredAt, &receipt.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		acked = append(acked, &receipt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return acked, nil
+}
+
+// Statuses returns the delivery status of the sender's messages among messageIds, keyed by
+// message ID. Other messages are left out.
+func (m ReceiptsModel) Statuses(senderId int64, messageIds []string) (map[string]*DeliveryStatus, error) {
+	statuses := make(map[string]*DeliveryStatus)
+	if len(messageIds) == 0 {
+		return statuses, nil
+	}
+
+	query := `
+		SELECT m.message_id, count(cm.user_id), count(r.delivered_at), count(r.read_at)
+		FROM messages m
+		LEFT JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id <> m.sender_id
+		LEFT JOIN message_receipts r ON r.message_id = m.message_id AND r.user_id = cm.user_id
+		WHERE m.message_id = ANY($1::int[]) AND m.sender_id = $2 AND m.type <> 'system' AND m.deleted_at IS NULL
+		GROUP BY m.message_id;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(messageIds), senderId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var messageId string
+		var recipients, delivered, read int
+		if err := rows.Scan(&messageId, &recipients, &delivered, &read); err != nil {
+			return nil, err
+		}
+		statuses[messageId] = newDeliveryStatus(recipients, delivered, read)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// ForMessage returns the receipts of every recipient of a message the sender sent to the
+// conversation, together with its delivery status. ErrRecordNotFound is returned if the message
+// doesn't exist, is deleted or wasn't sent by the sender.
+func (m ReceiptsModel) ForMessage(senderId int64, conversationId, messageId int) ([]*Receipt, *DeliveryStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM messages m
+			WHERE m.message_id = $1 AND m.conversation_id = $2 AND m.sender_id = $3 AND m.type <> 'system'
+			AND m.deleted_at IS NULL AND `+notExpired+`
+		);`, messageId, conversationId, senderId).Scan(&exists)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT cm.user_id, u.name, r.delivered_at, r.read_at
+		FROM conversation_members cm
+		INNER JOIN users u ON u.id = cm.user_id
+		LEFT JOIN message_receipts r ON r.message_id = $1 AND r.user_id = cm.user_id
+		WHERE cm.conversation_id = $2 AND cm.user_id <> $3
+		ORDER BY r.read_at NULLS LAST, r.delivered_at NULLS LAST, cm.user_id;
+		`
+	rows, err := m.DB.QueryContext(ctx, query, messageId, conversationId, senderId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	receipts := []*Receipt{}
+	var delivered, read int
+	for rows.Next() {
+		var receipt Receipt
+		if err := rows.Scan(&receipt.UserId, &receipt.Name, &receipt.DeliveredAt, &receipt.ReadAt); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case receipt.ReadAt != nil:
+			receipt.State = DeliveryRead
+			read++
+			delivered++
+		case receipt.DeliveredAt != nil:
+			receipt.State = DeliveryDelivered
+			delivered++
+		default:
+			receipt.State = DeliverySent
+		}
+		receipts = append(receipts, &receipt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return receipts, newDeliveryStatus(len(receipts), delivered, read), nil
+}
+
+// ValidateAck checks a batch of acknowledged message IDs.
+func ValidateAck(v *validator.Validator, delivered, read []int) {
+	v.Check(len(delivered)+len(read) > 0, "delivered", "must contain at least one message, or read must")
+	v.Check(len(delivered)+len(read) <= MaxReceiptBatch, "delivered", "must not contain more than 500 messages together with read")
+	for key, ids := range map[string][]int{"delivered": delivered, "read": read} {
+		for _, id := range ids {
+			if id <= 0 {
+				v.AddError(key, "must only contain valid message IDs")
+				break
+			}
+		}
+	}
+}
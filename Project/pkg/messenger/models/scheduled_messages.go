@@ -0,0 +1,329 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/KarenMirzayan/Project/pkg/messenger/validator"
+	"github.com/lib/pq"
+)
+
+// Statuses of a scheduled message. Only scheduled messages can still be edited or cancelled.
+const (
+	ScheduleStatusScheduled = "scheduled"
+	ScheduleStatusSent      = "sent"
+	ScheduleStatusFailed    = "failed"
+	ScheduleStatusCancelled = "cancelled"
+)
+
+// MaxScheduleAhead is how far in the future a message can be scheduled.
+const MaxScheduleAhead = 365 * 24 * time.Hour
+
+// ScheduledMessage is a message that is sent to a conversation at SendAt. Once it went out,
+// MessageId refers to the message that was created, and if it couldn't be sent Error says why.
+type ScheduledMessage struct {
+	Id             int64     `json:"id"`
+	ConversationId int       `json:"conversation_id"`
+	SenderId       int64     `json:"sender_id"`
+	Content        string    `json:"content"`
+	ReplyTo        *int      `json:"reply_to_message_id,omitempty"`
+	AttachmentIds  []int64   `json:"attachment_ids"`
+	SendAt         time.Time `json:"send_at"`
+	Status         string    `json:"status"`
+	MessageId      *int      `json:"message_id,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"version"`
+}
+
+// scheduledMessageColumns lists the columns scanned by (*ScheduledMessage).scanDest.
+const scheduledMessageColumns = `id, conversation_id, sender_id, content, reply_to_message_id, attachment_ids, send_at,
+	status, message_id, error, created_at, updated_at, version`
+
+// scanDest returns the scan destinations matching scheduledMessageColumns.
+func (s *ScheduledMessage) scanDest() []interface{} {
+	return []interface{}{&s.Id, &s.ConversationId, &s.SenderId, &s.Content, &s.ReplyTo,
+		(*pq.Int64Array)(&s.AttachmentIds), &s.SendAt, &s.Status, &s.MessageId, &s.Error, &s.CreatedAt,
+		&s.UpdatedAt, &s.Version}
+}
+
+type ScheduledMessagesModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert schedules a message in a conversation the sender is a member of. ErrRecordNotFound is
+// returned if they aren't.
+func (m ScheduledMessagesModel) Insert(s *ScheduledMessage) error {
+	query := `
+		INSERT INTO scheduled_messages (conversation_id, sender_id, content, reply_to_message_id, attachment_ids, send_at)
+		SELECT cm.conversation_id, cm.user_id, $3, $4, $5, $6
+		FROM conversation_members cm
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2
+		RETURNING ` + scheduledMessageColumns + `;
+		`
+	args := []interface{}{s.ConversationId, s.SenderId, s.Content, s.ReplyTo, pq.Array(s.AttachmentIds), s.SendAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(s.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a scheduled message of the sender in a conversation, whatever its status.
+func (m ScheduledMessagesModel) Get(conversationId int, senderId, id int64) (*ScheduledMessage, error) {
+	query := `
+		SELECT ` + scheduledMessageColumns + `
+		FROM scheduled_messages
+		WHERE id = $1 AND conversation_id = $2 AND sender_id = $3;
+		`
+	var s ScheduledMessage
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, conversationId, senderId).Scan(s.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// GetAll returns a page of the sender's scheduled messages in a conversation that haven't gone
+// out yet, or failed to, in the order they are due.
+func (m ScheduledMessagesModel) GetAll(conversationId int, senderId int64, filters Filters) ([]*ScheduledMessage, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + scheduledMessageColumns + `
+		FROM scheduled_messages
+		WHERE conversation_id = $1 AND sender_id = $2 AND status IN ('scheduled', 'failed')
+		ORDER BY send_at, id
+		LIMIT $3 OFFSET $4;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, senderId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+	scheduled := []*ScheduledMessage{}
+	for rows.Next() {
+		var s ScheduledMessage
+		if err := rows.Scan(append([]interface{}{&totalRecords}, s.scanDest()...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		scheduled = append(scheduled, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return scheduled, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update saves the content, reply target, attachments and send time of a scheduled message.
+// ErrEditConflict is returned if the version doesn't match or the message is no longer scheduled,
+// for instance because it was sent in the meantime.
+func (m ScheduledMessagesModel) Update(s *ScheduledMessage) error {
+	query := `
+		UPDATE scheduled_messages
+		SET content = $1, reply_to_message_id = $2, attachment_ids = $3, send_at = $4, updated_at = NOW(),
+			version = version + 1
+		WHERE id = $5 AND sender_id = $6 AND version = $7 AND status = 'scheduled'
+		RETURNING updated_at, version;
+		`
+	args := []interface{}{s.Content, s.ReplyTo, pq.Array(s.AttachmentIds), s.SendAt, s.Id, s.SenderId, s.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.UpdatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel stops a scheduled message from being sent. ErrEditConflict is returned if it is no
+// longer scheduled.
+func (m ScheduledMessagesModel) Cancel(s *ScheduledMessage) error {
+	query := `
+		UPDATE scheduled_messages
+		SET status = 'cancelled', updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND sender_id = $2 AND status = 'scheduled'
+		RETURNING status, updated_at, version;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, s.Id, s.SenderId).Scan(&s.Status, &s.UpdatedAt, &s.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// DispatchDue sends up to limit scheduled messages whose time has come, through the same path as
+// messages sent directly. It returns the messages that were created and how many scheduled
+// messages were handled, including those that failed. Every message is claimed with FOR UPDATE
+// SKIP LOCKED and sent in the same transaction that marks it as sent, so concurrent dispatchers
+// never send a message twice and a crash leaves it scheduled. Messages that can no longer be
+// sent, e.g. because the sender left the conversation, are marked as failed.
+func (m ScheduledMessagesModel) DispatchDue(messages MessagesModel, limit int) ([]*Messages, int, error) {
+	var sent []*Messages
+	handled := 0
+	for handled < limit {
+		message, found, err := m.dispatchOne(messages)
+		if err != nil {
+			return sent, handled, err
+		}
+		if !found {
+			break
+		}
+
+		handled++
+		if message != nil {
+			sent = append(sent, message)
+		}
+	}
+	return sent, handled, nil
+}
+
+// dispatchOne sends the scheduled message that has been due the longest. found is false if no
+// message is due, and the message is nil if it couldn't be sent.
+func (m ScheduledMessagesModel) dispatchOne(messages MessagesModel) (*Messages, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + scheduledMessageColumns + `
+		FROM scheduled_messages
+		WHERE status = 'scheduled' AND send_at <= NOW()
+		ORDER BY send_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED;
+		`
+	var s ScheduledMessage
+	err = tx.QueryRowContext(ctx, query).Scan(s.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, nil
+		default:
+			return nil, false, err
+		}
+	}
+
+	message := &Messages{
+		ConversationId: strconv.Itoa(s.ConversationId),
+		SenderId:       int(s.SenderId),
+		Content:        s.Content,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+		ReplyTo:        s.ReplyTo,
+		AttachmentIds:  s.AttachmentIds,
+	}
+
+	// The savepoint lets us undo a half-inserted message and still record the failure.
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT dispatch;`); err != nil {
+		return nil, true, err
+	}
+
+	err = messages.insert(ctx, tx, message)
+	if err != nil {
+		reason, ok := dispatchFailure(err)
+		if !ok {
+			return nil, true, err
+		}
+
+		if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT dispatch;`); err != nil {
+			return nil, true, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE scheduled_messages
+			SET status = 'failed', error = $2, updated_at = NOW(), version = version + 1
+			WHERE id = $1;`, s.Id, reason)
+		if err != nil {
+			return nil, true, err
+		}
+		return nil, true, tx.Commit()
+	}
+
+	messageId, _ := strconv.Atoi(message.MessageId)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE scheduled_messages
+		SET status = 'sent', message_id = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $1;`, s.Id, messageId)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return message, true, tx.Commit()
+}
+
+// dispatchFailure explains why a scheduled message couldn't be sent. ok is false for errors that
+// aren't caused by the message itself, which are worth retrying.
+func dispatchFailure(err error) (reason string, ok bool) {
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		return "the sender is no longer a member of the conversation", true
+	case errors.Is(err, ErrInvalidReply):
+		return "the message replied to no longer exists", true
+	case errors.Is(err, ErrInvalidAttachment):
+		return "an attachment no longer exists", true
+	case errors.Is(err, ErrInvalidMention):
+		return "the message mentions users outside the conversation", true
+	default:
+		return "", false
+	}
+}
+
+func ValidateScheduledMessage(v *validator.Validator, s *ScheduledMessage, now time.Time) {
+	v.Check(s.Content != "", "content", "must be provided")
+	v.Check(utf8.RuneCountInString(s.Content) <= MaxMessageLength, "content",
+		fmt.Sprintf("must not be more than %d characters long", MaxMessageLength))
+	v.Check(s.SendAt.After(now), "send_at", "must be in the future")
+	v.Check(s.SendAt.Before(now.Add(MaxScheduleAhead)), "send_at", "must not be more than a year ahead")
+	ValidateAttachmentIDs(v, s.AttachmentIds)
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// maxSearchTerms bounds the number of terms in a search query, to keep the generated tsquery
+// cheap to evaluate.
+const maxSearchTerms = 16
+
+var (
+	// ErrEmptySearch is returned when a search query doesn't contain anything to search for.
+	ErrEmptySearch = errors.New("search query contains no words")
+
+	// ErrTooManySearchTerms is returned when a search query has more than maxSearchTerms terms.
+	ErrTooManySearchTerms = errors.New("search query has too many terms")
+)
+
+// ParseSearchQuery turns the search syntax accepted by the API into a to_tsquery expression.
+// Terms are combined with AND unless separated by "or":
+//
+//	word     messages containing the word
+//	"a b"    messages containing the exact phrase
+//	pre*     messages containing a word starting with "pre"
+//	-term    messages not containing the term, works with words, phrases and prefixes
+//	a or b   messages containing either term
+//
+// Only letters and digits make it into the result, so user input can never introduce tsquery
+// operators of its own.
+func ParseSearchQuery(query string) (string, error) {
+	var (
+		b        strings.Builder
+		terms    int
+		operator = " & "
+		runes    = []rune(query)
+	)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		negate := false
+		if runes[i] == '-' {
+			negate = true
+			i++
+		}
+
+		var term string
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			term = searchTerm(string(runes[i+1:min(end, len(runes))]), false)
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+
+			if !negate && strings.EqualFold(word, "or") {
+				if terms > 0 {
+					operator = " | "
+				}
+				continue
+			}
+			term = searchTerm(strings.TrimSuffix(word, "*"), strings.HasSuffix(word, "*"))
+		}
+
+		if term == "" {
+			continue
+		}
+		if terms++; terms > maxSearchTerms {
+			return "", ErrTooManySearchTerms
+		}
+
+		if b.Len() > 0 {
+			b.WriteString(operator)
+		}
+		operator = " & "
+		if negate {
+			b.WriteString("!")
+		}
+		b.WriteString(term)
+	}
+
+	if b.Len() == 0 {
+		return "", ErrEmptySearch
+	}
+	return b.String(), nil
+}
+
+// searchTerm splits text into its words and joins them as a phrase. A single word is returned
+// on its own, optionally as a prefix match.
+func searchTerm(text string, prefix bool) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	for i, word := range words {
+		words[i] = "'" + strings.ToLower(word) + "'"
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+// Search highlights are marked with characters from the Unicode private use area, so that the
+// snippet can be HTML-escaped before the markers are turned into <mark> tags.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+
+	headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop +
+		", MaxWords=25, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \""
+)
+
+// highlightSnippet escapes a ts_headline snippet for HTML and wraps the matches in <mark> tags.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, highlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, highlightStop, "</mark>")
+}
+
+// searchLanguages returns the text search configurations of the rows selected by query, which
+// must select the distinct search_language values as text.
+func searchLanguages(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []string
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			return nil, err
+		}
+		languages = append(languages, language)
+	}
+	return languages, rows.Err()
+}
+
+// Rows are searched in the language they were indexed with. Building the tsquery from the row's
+// own search_language would keep Postgres from using the GIN index on search_vector, so every
+// language in use gets its own tsquery with a constant configuration instead. The functions below
+// take the alias of the searched table and the placeholder of the parsed query.
+
+// searchMatch returns the condition matching the rows of table against the query.
+func searchMatch(table string, languages []string, query string) string {
+	if len(languages) == 0 {
+		return "FALSE"
+	}
+	conditions := make([]string, len(languages))
+	for i, language := range languages {
+		config := pq.QuoteLiteral(language) + "::regconfig"
+		conditions[i] = "(" + table + ".search_language = " + config + " AND " +
+			table + ".search_vector @@ to_tsquery(" + config + ", " + query + "))"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// searchRank returns the ts_rank of the rows of table for the query.
+func searchRank(table string, languages []string, query string) string {
+	return searchCase(table, languages, func(config string) string {
+		return "ts_rank(" + table + ".search_vector, to_tsquery(" + config + ", " + query + "))"
+	}, "0::real")
+}
+
+// searchSnippet returns the ts_headline of the content of the rows of table for the query, made
+// with the options in the given placeholder.
+func searchSnippet(table string, languages []string, query, options string) string {
+	return searchCase(table, languages, func(config string) string {
+		return "ts_headline(" + config + ", " + table + ".content, to_tsquery(" + config + ", " + query + "), " +
+			options + ")"
+	}, "''")
+}
+
+// searchCase picks the expression made for the row's language, or otherwise if there is none.
+func searchCase(table string, languages []string, expr func(config string) string, otherwise string) string {
+	var b strings.Builder
+	b.WriteString("CASE " + table + ".search_language")
+	for _, language := range languages {
+		config := pq.QuoteLiteral(language) + "::regconfig"
+		b.WriteString(" WHEN " + config + " THEN " + expr(config))
+	}
+	b.WriteString(" ELSE " + otherwise + " END")
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		err   error
+	}{
+		{query: "hello", want: "'hello'"},
+		{query: "  Hello   World ", want: "'hello' & 'world'"},
+		{query: `"good morning"`, want: "('good' <-> 'morning')"},
+		{query: `"unterminated phrase`, want: "('unterminated' <-> 'phrase')"},
+		{query: "deploy*", want: "'deploy':*"},
+		{query: "-spam", want: "!'spam'"},
+		{query: `-"daily report"`, want: "!('daily' <-> 'report')"},
+		{query: "cats or dogs", want: "'cats' | 'dogs'"},
+		{query: "cats OR dogs birds", want: "'cats' | 'dogs' & 'birds'"},
+		{query: "or cats", want: "'cats'"},
+		{query: "-or", want: "!'or'"},
+		{query: "it's", want: "('it' <-> 's')"},
+		{query: "a&b | !c", want: "('a' <-> 'b') & 'c'"},
+		{query: "привет мир", want: "'привет' & 'мир'"},
+		{query: "", err: ErrEmptySearch},
+		{query: "!!! & |", err: ErrEmptySearch},
+		{query: `"" -`, err: ErrEmptySearch},
+		{query: strings.Repeat("word ", maxSearchTerms), want: strings.TrimSuffix(strings.Repeat("'word' & ", maxSearchTerms), " & ")},
+		{query: strings.Repeat("word ", maxSearchTerms+1), err: ErrTooManySearchTerms},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSearchQuery(tt.query)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseSearchQuery(%q) error = %v, want %v", tt.query, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSearchQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	snippet := "a <b> " + highlightStart + "match" + highlightStop + " & more"
+	want := "a &lt;b&gt; <mark>match</mark> &amp; more"
+	if got := highlightSnippet(snippet); got != want {
+		t.Errorf("highlightSnippet() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchExpressions(t *testing.T) {
+	languages := []string{"english", "it's"}
+
+	match := searchMatch("m", languages, "$5")
+	want := "((m.search_language = 'english'::regconfig AND m.search_vector @@ to_tsquery('english'::regconfig, $5)) OR " +
+		"(m.search_language = 'it''s'::regconfig AND m.search_vector @@ to_tsquery('it''s'::regconfig, $5)))"
+	if match != want {
+		t.Errorf("searchMatch() = %q, want %q", match, want)
+	}
+	if match := searchMatch("m", nil, "$5"); match != "FALSE" {
+		t.Errorf("searchMatch() without languages = %q, want %q", match, "FALSE")
+	}
+
+	rank := searchRank("m", languages[:1], "$5")
+	want = "CASE m.search_language WHEN 'english'::regconfig THEN " +
+		"ts_rank(m.search_vector, to_tsquery('english'::regconfig, $5)) ELSE 0::real END"
+	if rank != want {
+		t.Errorf("searchRank() = %q, want %q", rank, want)
+	}
+
+	snippet := searchSnippet("m", languages[:1], "$5", "$6")
+	want = "CASE m.search_language WHEN 'english'::regconfig THEN " +
+		"ts_headline('english'::regconfig, m.content, to_tsquery('english'::regconfig, $5), $6) ELSE '' END"
+	if snippet != want {
+		t.Errorf("searchSnippet() = %q, want %q", snippet, want)
+	}
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of changes to the messages of a conversation.
+const (
+	ChangeCreated = "created"
+	ChangeEdited  = "edited"
+	ChangeDeleted = "deleted"
+)
+
+// Change is what happened to one message within a range of change sequence numbers. A message
+// that changed several times is reported once, as it is now: created if it was sent within the
+// range, deleted if it is gone, and edited otherwise. Message is the current state of the message,
+// a tombstone for deleted messages that still have one, and nil for those removed for good.
+type Change struct {
+	ChangeSeq int64     `json:"change_seq"`
+	Kind      string    `json:"kind"`
+	MessageId int       `json:"message_id"`
+	Message   *Messages `json:"message,omitempty"`
+}
+
+// nextMessageSeq takes the next message sequence number of a conversation as part of the
+// transaction sending the message. The conversation stays locked until the transaction ends, which
+// keeps the numbers in order and without gaps.
+func nextMessageSeq(ctx context.Context, tx *sql.Tx, conversationId string) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE user_conversations SET last_message_seq = last_message_seq + 1
+		WHERE conversation_id = $1
+		RETURNING last_message_seq;`, conversationId).Scan(&seq)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// recordChange appends a change to a message to the change log of its conversation, as part of
+// the transaction making the change.
+func recordChange(ctx context.Context, tx *sql.Tx, conversationId, messageId string, kind string) error {
+	return recordChangeFor(ctx, tx, conversationId, messageId, nil, kind)
+}
+
+// recordUserChange is like recordChange for a change only the given user sees, such as deleting a
+// message for themselves. Other members skip it when they sync.
+func recordUserChange(ctx context.Context, tx *sql.Tx, conversationId, messageId string, userId int64, kind string) error {
+	return recordChangeFor(ctx, tx, conversationId, messageId, &userId, kind)
+}
+
+func recordChangeFor(ctx context.Context, tx *sql.Tx, conversationId, messageId string, userId *int64, kind string) error {
+	_, err := tx.ExecContext(ctx, `
+		WITH seq AS (
+			UPDATE user_conversations SET last_change_seq = last_change_seq + 1
+			WHERE conversation_id = $1
+			RETURNING last_change_seq
+		)
+		INSERT INTO conversation_changes (conversation_id, change_seq, message_id, kind, user_id)
+		SELECT $1, last_change_seq, $2, $3, $4 FROM seq;`, conversationId, messageId, kind, userId)
+	return err
+}
+
+// LastChangeSeq returns the change sequence number of the latest change in a conversation, 0 if
+// nothing happened yet.
+func (m MessagesModel) LastChangeSeq(conversationId int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var seq int64
+	err := m.DB.QueryRowContext(ctx, `SELECT last_change_seq FROM user_conversations WHERE conversation_id = $1;`,
+		conversationId).Scan(&seq)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// Changes returns the messages of a conversation that changed after the change sequence number
+// since and up to upto, as seen by the user, ordered by their latest change. Only messages whose
+// latest change in the range comes after after are returned, at most limit of them, which lets
+// callers page through the range. Messages the user can't see anymore count as deleted. Changes
+// meant for other users only are skipped.
+func (m MessagesModel) Changes(userId int64, conversationId int, since, after, upto int64, limit int) ([]*Change, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT message_id, max(change_seq), bool_or(kind = 'created')
+		FROM conversation_changes
+		WHERE conversation_id = $1 AND change_seq > $2 AND change_seq <= $4 AND (user_id IS NULL OR user_id = $6)
+		GROUP BY message_id
+		HAVING max(change_seq) > $3
+		ORDER BY 2
+		LIMIT $5;
+		`
+	rows, err := m.DB.QueryContext(ctx, query, conversationId, since, after, upto, limit, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var (
+		changes = []*Change{}
+		ids     []int
+		byId    = make(map[int]*Change)
+		created = make(map[int]bool)
+	)
+	for rows.Next() {
+		var change Change
+		var wasCreated bool
+		if err := rows.Scan(&change.MessageId, &change.ChangeSeq, &wasCreated); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+		ids = append(ids, change.MessageId)
+		byId[change.MessageId] = &change
+		created[change.MessageId] = wasCreated
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	// The messages as they are now. Those the user deleted for themselves or that have expired
+	// are left out, just like deleted ones.
+	query = `
+		SELECT ` + messageColumns + `, ` + replyCountColumn + `
+		FROM messages m
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $2
+		WHERE m.message_id = ANY($1::int[]) AND ` + notExpired + ` AND ` + notHidden + `;
+		`
+	messageRows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := messageRows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for messageRows.Next() {
+		var message Messages
+		if err := messageRows.Scan(append(message.scanDest(), &message.ReplyCount)...); err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(message.MessageId)
+		if err != nil {
+			return nil, err
+		}
+		if change, ok := byId[id]; ok {
+			change.Message = &message
+		}
+	}
+	if err = messageRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		switch {
+		case change.Message == nil || change.Message.DeletedAt != nil:
+			change.Kind = ChangeDeleted
+		case created[change.MessageId]:
+			change.Kind = ChangeCreated
+		default:
+			change.Kind = ChangeEdited
+		}
+	}
+	return changes, nil
+}
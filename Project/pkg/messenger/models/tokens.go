@@ -89,6 +89,19 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Scope:  scope,
 	}
 
+	plaintext, hash, err := generateCode()
+	if err != nil {
+		return nil, err
+	}
+	token.Plaintext = plaintext
+	token.Hash = hash
+
+	return token, nil
+}
+
+// generateCode returns a random, 26 character plaintext code together with the SHA-256 hash of
+// it. Only the hash is ever stored in the database. It is used for tokens and invite codes.
+func generateCode() (string, []byte, error) {
 	// Initialize a zero-valued byte slice with a length of 16 bytes.
 	randomBytes := make([]byte, 16)
 
@@ -97,29 +110,27 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// to function correctly.
 	_, err := rand.Read(randomBytes)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	// Encode the byte slice to a base-32 encoded string and assign it to the token Plaintext field.
-	// This will be the token string that we send to the user in their welcome email. They will
-	// look similar to this:
+	// Encode the byte slice to a base-32 encoded string. This will be the code that we send to
+	// the user, for example in their welcome email. They will look similar to this:
 	//
 	// Y3QMGX3PJ3WLRL2YRTQGQ6KRHU
 	//
 	// Note that by default base-32 strings may be padded at the end with the = character.
-	// However, we don't need this padding character for the purpose of our tokens, so we use
+	// However, we don't need this padding character for the purpose of our codes, so we use
 	// the WithPadding(base32.NoPadding) method in the line below to omit them.
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// Generate a SHA-256 hash of the plaintext token string. This will be the value
-	// that we store in the `hash` field of our tokens table.
+	// Generate a SHA-256 hash of the plaintext code. This will be the value that we store in
+	// the `hash` field of the table.
 	// Note, that the sha256.Sum256() function returns an *array* of length 32,
 	// so to make it easier to work with we convert it to a slice using the [:]
 	// operator before operating on it.
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	hash := sha256.Sum256([]byte(plaintext))
 
-	return token, nil
+	return plaintext, hash[:], nil
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
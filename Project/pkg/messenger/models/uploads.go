@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrInvalidUpload is returned when a message references an upload that doesn't exist, isn't
+	// finished or belongs to somebody else.
+	ErrInvalidUpload = errors.New("invalid upload")
+)
+
+// Upload is a resumable upload. Once all Length bytes have been received it is turned into an
+// attachment, and AttachmentId is set. Parts holds the blob store keys of the chunks received so
+// far, in order.
+type Upload struct {
+	ID           string
+	OwnerId      int64
+	Length       int64
+	Received     int64
+	Parts        []string
+	Filename     string
+	AttachmentId *int64
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// NewPartKey returns a new blob store key for a chunk that starts at the given offset. Every
+// attempt to store a chunk gets its own key, so that concurrent requests sending the same chunk
+// never write to, or clean up, each other's blob.
+func (u *Upload) NewPartKey(offset int64) (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("uploads/%s/%015d-%s", u.ID, offset, hex.EncodeToString(randomBytes)), nil
+}
+
+// Complete reports whether every byte of the upload has been received.
+func (u *Upload) Complete() bool {
+	return u.Received == u.Length
+}
+
+const uploadColumns = `id, owner_id, length, received, part_keys, filename, attachment_id, created_at, expires_at`
+
+func (u *Upload) scanDest() []interface{} {
+	return []interface{}{&u.ID, &u.OwnerId, &u.Length, &u.Received, (*pq.StringArray)(&u.Parts), &u.Filename,
+		&u.AttachmentId, &u.CreatedAt, &u.ExpiresAt}
+}
+
+type UploadsModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// New creates an upload of the given length that expires after ttl. As with attachments, the
+// owner's row is locked while checking that the upload fits in the quota, counting both their
+// attachments and their other unfinished uploads.
+func (m UploadsModel) New(ownerId, length int64, filename string, ttl time.Duration, quota int64) (*Upload, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{
+		ID:       hex.EncodeToString(randomBytes),
+		OwnerId:  ownerId,
+		Length:   length,
+		Filename: filename,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var used int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT SUM(size) FROM attachments WHERE owner_id = u.id), 0) +
+			COALESCE((SELECT SUM(length) FROM uploads WHERE owner_id = u.id AND attachment_id IS NULL), 0)
+		FROM users u
+		WHERE u.id = $1
+		FOR UPDATE OF u;`, ownerId).Scan(&used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if used+length > quota {
+		return nil, ErrQuotaExceeded
+	}
+
+	query := `
+		INSERT INTO uploads (id, owner_id, length, filename, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5))
+		RETURNING ` + uploadColumns + `;
+		`
+	args := []interface{}{upload.ID, upload.OwnerId, upload.Length, upload.Filename, ttl.Seconds()}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(upload.scanDest()...); err != nil {
+		return nil, err
+	}
+
+	return upload, tx.Commit()
+}
+
+// Get returns an unexpired upload of the owner, or ErrRecordNotFound.
+func (m UploadsModel) Get(id string, ownerId int64) (*Upload, error) {
+	query := `
+		SELECT ` + uploadColumns + `
+		FROM uploads
+		WHERE id = $1 AND owner_id = $2 AND expires_at > NOW();
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+	err := m.DB.QueryRowContext(ctx, query, id, ownerId).Scan(upload.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &upload, nil
+}
+
+// AddPart records a chunk of n bytes starting at offset, stored under key. The offset must still
+// be the number of bytes received so far, otherwise another request got there first and
+// ErrEditConflict is returned. The chunk under key is then not part of the upload.
+func (m UploadsModel) AddPart(upload *Upload, offset, n int64, key string) error {
+	query := `
+		UPDATE uploads
+		SET received = received + $3, part_keys = array_append(part_keys, $4::text)
+		WHERE id = $1 AND received = $2 AND received + $3 <= length AND expires_at > NOW()
+		RETURNING ` + uploadColumns + `;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, upload.ID, offset, n, key).Scan(upload.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// SetAttachment links a finished upload to the attachment it was turned into. Its chunks are no
+// longer needed after this.
+func (m UploadsModel) SetAttachment(upload *Upload, attachmentId int64) error {
+	query := `
+		UPDATE uploads
+		SET attachment_id = $2, part_keys = '{}'
+		WHERE id = $1;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := m.DB.ExecContext(ctx, query, upload.ID, attachmentId); err != nil {
+		return err
+	}
+	upload.AttachmentId = &attachmentId
+	upload.Parts = nil
+	return nil
+}
+
+// Delete removes an upload of the owner and returns it, so the caller can remove its chunks.
+func (m UploadsModel) Delete(id string, ownerId int64) (*Upload, error) {
+	query := `
+		DELETE FROM uploads
+		WHERE id = $1 AND owner_id = $2
+		RETURNING ` + uploadColumns + `;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+	err := m.DB.QueryRowContext(ctx, query, id, ownerId).Scan(upload.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &upload, nil
+}
+
+// DeleteExpired removes up to limit expired uploads and returns them, so the caller can remove
+// their chunks. Like DeleteExpired on messages it can run on several instances at once.
+func (m UploadsModel) DeleteExpired(limit int) ([]*Upload, error) {
+	query := `
+		DELETE FROM uploads
+		WHERE id IN (
+			SELECT id
+			FROM uploads
+			WHERE expires_at <= NOW()
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + uploadColumns + `;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var uploads []*Upload
+	for rows.Next() {
+		var upload Upload
+		if err := rows.Scan(upload.scanDest()...); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &upload)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// AttachmentIDs resolves finished uploads of the owner to their attachments, in the same order.
+// ErrInvalidUpload is returned if any of them is unknown or unfinished.
+func (m UploadsModel) AttachmentIDs(ownerId int64, uploadIds []string) ([]int64, error) {
+	if len(uploadIds) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT up.attachment_id
+		FROM unnest($1::text[]) WITH ORDINALITY AS ids (id, position)
+		INNER JOIN uploads up ON up.id = ids.id
+		WHERE up.owner_id = $2 AND up.attachment_id IS NOT NULL
+		ORDER BY ids.position;
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(uploadIds), ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != len(uploadIds) {
+		return nil, ErrInvalidUpload
+	}
+	return ids, nil
+}
@@ -21,13 +21,15 @@ var AnonymousUser = &User{}
 // the Password and Version fields from appearing in any output when we encode it to JSON.
 // Also, notice that the Password field uses the custom password type defined below.
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID                      int64     `json:"id"`
+	CreatedAt               time.Time `json:"created_at"`
+	Name                    string    `json:"name"`
+	Email                   string    `json:"email"`
+	Handle                  *string   `json:"handle"`
+	AllowForwardAttribution bool      `json:"allow_forward_attribution"`
+	Password                password  `json:"-"`
+	Activated               bool      `json:"activated"`
+	Version                 int       `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -123,7 +125,7 @@ func (m UserModel) Insert(user *User) error {
 	query := `
 INSERT INTO users (name, email, password_hash, activated)
 VALUES ($1, $2, $3, $4)
-RETURNING id, created_at, version`
+RETURNING id, created_at, allow_forward_attribution, version`
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -131,7 +133,7 @@ RETURNING id, created_at, version`
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.AllowForwardAttribution, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -148,7 +150,7 @@ RETURNING id, created_at, version`
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, handle, allow_forward_attribution, password_hash, activated, version
 FROM users
 WHERE email = $1`
 	var user User
@@ -159,6 +161,8 @@ WHERE email = $1`
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.Handle,
+		&user.AllowForwardAttribution,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
@@ -209,6 +213,51 @@ RETURNING version`
 	return nil
 }
 
+// SetHandle changes the handle of a user, or removes it if the handle is nil. ErrDuplicateHandle
+// is returned if another user already has the handle, regardless of case.
+func (m UserModel) SetHandle(user *User) error {
+	query := `
+UPDATE users
+SET handle = $1, version = version + 1
+WHERE id = $2
+RETURNING version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, user.Handle, user.ID).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_handle_key"`:
+			return ErrDuplicateHandle
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateSettings saves the user's personal settings.
+func (m UserModel) UpdateSettings(user *User) error {
+	query := `
+UPDATE users
+SET allow_forward_attribution = $1, version = version + 1
+WHERE id = $2
+RETURNING version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, user.AllowForwardAttribution, user.ID).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
 // GetForToken retrieves a user record from the users table for an associated token and token scope.
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash for the plaintext token provided by the client.
@@ -217,8 +266,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	query := `
 		SELECT 
-			users.id, users.created_at, users.name, users.email, 
-			users.password_hash, users.activated, users.version
+			users.id, users.created_at, users.name, users.email, users.handle,
+			users.allow_forward_attribution, users.password_hash, users.activated, users.version
 		FROM       users
         INNER JOIN tokens
 			ON users.id = tokens.user_id
@@ -247,6 +296,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.Handle,
+		&user.AllowForwardAttribution,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
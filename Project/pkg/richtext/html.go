@@ -0,0 +1,71 @@
+package richtext
+
+import (
+	"html"
+	"strings"
+)
+
+// tags is the allowlist of HTML elements entities are rendered as. Nothing else ever makes it
+// into the output.
+var tags = map[string]string{
+	TypeBold:   "strong",
+	TypeItalic: "em",
+	TypeCode:   "code",
+	TypePre:    "pre",
+	TypeLink:   "a",
+	TypeQuote:  "blockquote",
+}
+
+// RenderHTML renders formatted text as HTML. All of the text is escaped and the only markup is
+// built from the allowlisted tags above, with href on links being the only attribute, so the
+// result is safe to embed whatever the text contains. Line breaks become <br> outside of pre
+// blocks. The entities must have passed Validate, anything else is rendered as plain text.
+func RenderHTML(text string, entities []Entity) string {
+	if Validate(text, entities) != nil {
+		entities = nil
+	}
+
+	sorted := Sorted(entities)
+	var b strings.Builder
+	var open []Entity
+	pre := 0
+	offset, next := 0, 0
+
+	closeUntil := func(offset int) {
+		for len(open) > 0 && open[len(open)-1].end() <= offset {
+			e := open[len(open)-1]
+			open = open[:len(open)-1]
+			b.WriteString("</" + tags[e.Type] + ">")
+			if e.Type == TypePre {
+				pre--
+			}
+		}
+	}
+
+	for _, r := range text {
+		closeUntil(offset)
+		for ; next < len(sorted) && sorted[next].Offset == offset; next++ {
+			e := sorted[next]
+			switch e.Type {
+			case TypeLink:
+				b.WriteString(`<a href="` + html.EscapeString(e.URL) + `" rel="nofollow noopener noreferrer">`)
+			case TypePre:
+				b.WriteString("<pre>")
+				pre++
+			default:
+				b.WriteString("<" + tags[e.Type] + ">")
+			}
+			open = append(open, e)
+		}
+
+		if r == '\n' && pre == 0 {
+			b.WriteString("<br>")
+		} else {
+			b.WriteString(html.EscapeString(string(r)))
+		}
+		offset += utf16Len(r)
+	}
+	closeUntil(offset)
+
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package richtext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseMarkdown turns text written in a restricted Markdown dialect into plain text and the
+// entities formatting it. The dialect knows
+//
+//	**bold**, *italic* or _italic_, `code`, [links](https://example.com),
+//	```language
+//	code blocks
+//	```
+//	> and quotes, one or more lines starting with ">"
+//
+// A backslash escapes the next punctuation character. Markup that isn't closed is kept as
+// literal text, so parsing never fails.
+func ParseMarkdown(src string) (string, []Entity) {
+	p := &parser{}
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); {
+		if i > 0 {
+			p.write("\n")
+		}
+		i = p.block(lines, i)
+	}
+	return p.out.String(), p.entities
+}
+
+type parser struct {
+	out      strings.Builder
+	offset   int
+	entities []Entity
+}
+
+// write appends literal text to the output.
+func (p *parser) write(s string) {
+	p.out.WriteString(s)
+	p.offset += Len(s)
+}
+
+// add records an entity over the output written since start, unless that is empty.
+func (p *parser) add(e Entity, start int) {
+	if p.offset > start {
+		e.Offset, e.Length = start, p.offset-start
+		p.entities = append(p.entities, e)
+	}
+}
+
+// block parses the block starting at lines[i] and returns the index of the line after it.
+func (p *parser) block(lines []string, i int) int {
+	line := lines[i]
+
+	if strings.HasPrefix(line, "```") {
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) != "```" {
+				continue
+			}
+
+			language := strings.TrimSpace(line[3:])
+			if !languageRX.MatchString(language) {
+				language = ""
+			}
+			start := p.offset
+			p.write(strings.Join(lines[i+1:j], "\n"))
+			p.add(Entity{Type: TypePre, Language: language}, start)
+			return j + 1
+		}
+	}
+
+	if strings.HasPrefix(line, ">") {
+		var quoted []string
+		j := i
+		for ; j < len(lines) && strings.HasPrefix(lines[j], ">"); j++ {
+			quoted = append(quoted, strings.TrimPrefix(lines[j][1:], " "))
+		}
+
+		start := p.offset
+		p.inline(strings.Join(quoted, "\n"))
+		p.add(Entity{Type: TypeQuote}, start)
+		return j
+	}
+
+	p.inline(line)
+	return i + 1
+}
+
+// inline parses the inline markup of s.
+func (p *parser) inline(s string) {
+	var prev rune
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isEscapable(s[i+1]):
+			p.write(s[i+1 : i+2])
+			prev = rune(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				start := p.offset
+				p.write(s[i+1 : i+1+end])
+				p.add(Entity{Type: TypeCode}, start)
+				prev = '`'
+				i += end + 2
+				continue
+			}
+
+		case c == '*' && strings.HasPrefix(s[i:], "**"):
+			if end := closing(s, i+2, "**"); end >= 0 {
+				start := p.offset
+				p.inline(s[i+2 : end])
+				p.add(Entity{Type: TypeBold}, start)
+				prev = '*'
+				i = end + 2
+				continue
+			}
+
+		case c == '*' || c == '_':
+			// An underscore inside a word, as in snake_case, is just an underscore.
+			if c == '*' || !isWordRune(prev) {
+				if end := closing(s, i+1, string(c)); end >= 0 {
+					next, _ := utf8.DecodeRuneInString(s[end+1:])
+					if c == '*' || !isWordRune(next) {
+						start := p.offset
+						p.inline(s[i+1 : end])
+						p.add(Entity{Type: TypeItalic}, start)
+						prev = rune(c)
+						i = end + 1
+						continue
+					}
+				}
+			}
+
+		case c == '[':
+			if text, url, n, ok := link(s[i:]); ok {
+				start := p.offset
+				p.inline(text)
+				p.add(Entity{Type: TypeLink, URL: url}, start)
+				prev = ')'
+				i += n
+				continue
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		p.write(s[i : i+size])
+		prev = r
+		i += size
+	}
+}
+
+// closing returns the index of the delimiter closing the one that ends at from, or -1 if there is
+// none. The enclosed text must neither be empty nor start or end with a space, escaped characters
+// and code spans are skipped, and a single "*" doesn't match half of a "**".
+func closing(s string, from int, delim string) int {
+	if from >= len(s) || s[from] == ' ' {
+		return -1
+	}
+	for i := from; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				i += end + 1
+			}
+		case strings.HasPrefix(s[i:], delim):
+			if len(delim) == 1 && strings.HasPrefix(s[i+1:], delim) {
+				i++
+				continue
+			}
+			if i > from && s[i-1] != ' ' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// link parses a link of the form [text](url) at the start of s and returns its text, URL and
+// length. Links with URLs that aren't allowed are left as text.
+func link(s string) (text, url string, n int, ok bool) {
+	closeText := strings.Index(s, "](")
+	if closeText <= 1 || strings.ContainsAny(s[1:closeText], "[]\n") {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+
+	url = s[closeText+2 : closeText+2+closeURL]
+	if strings.ContainsAny(url, " \t\n") || !ValidURL(url) {
+		return "", "", 0, false
+	}
+	return s[1:closeText], url, closeText + 3 + closeURL, true
+}
+
+// isEscapable reports whether a backslash in front of c escapes it.
+func isEscapable(c byte) bool {
+	return strings.IndexByte("\\`*_[]()>#", c) >= 0
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,176 @@
+// Package richtext describes formatted message text as plain text plus formatting entities, the
+// way clients render it. It parses a restricted Markdown dialect into that form and renders it as
+// HTML built only from an allowlist of tags.
+package richtext
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Entity types. Code and pre can't contain other entities, everything else nests.
+const (
+	TypeBold   = "bold"
+	TypeItalic = "italic"
+	TypeCode   = "code"
+	TypePre    = "pre"
+	TypeLink   = "link"
+	TypeQuote  = "quote"
+)
+
+// MaxEntities is the number of entities a single text may have.
+const MaxEntities = 100
+
+// Entity formats a range of the text. Offset and Length are counted in UTF-16 code units, like
+// string indices in JavaScript. URL is only set on links and Language only on pre blocks.
+type Entity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// end returns the offset just past the entity.
+func (e Entity) end() int {
+	return e.Offset + e.Length
+}
+
+var (
+	// ErrTooManyEntities is returned by Validate when there are more than MaxEntities entities.
+	ErrTooManyEntities = errors.New("too many entities")
+
+	// languageRX matches the language names allowed on pre blocks.
+	languageRX = regexp.MustCompile(`^[A-Za-z0-9_+#-]{1,32}$`)
+)
+
+// allowedSchemes are the URL schemes links may use.
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// ValidURL reports whether s is an absolute URL with an allowed scheme that can be used as a link.
+func ValidURL(s string) bool {
+	if s == "" || len(s) > 2048 {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil || !allowedSchemes[u.Scheme] {
+		return false
+	}
+	return u.Scheme == "mailto" && u.Opaque != "" || u.Host != ""
+}
+
+// Validate checks that the entities fit the text: every entity has a known type, covers a
+// non-empty range of the text that doesn't split a character, and either contains or stays clear
+// of the others. Links need a valid URL.
+func Validate(text string, entities []Entity) error {
+	if len(entities) > MaxEntities {
+		return ErrTooManyEntities
+	}
+
+	// Character boundaries in UTF-16 code units, so that no entity starts or ends inside a
+	// surrogate pair.
+	boundaries := map[int]bool{0: true}
+	length := 0
+	for _, r := range text {
+		length += utf16Len(r)
+		boundaries[length] = true
+	}
+
+	for _, e := range entities {
+		switch e.Type {
+		case TypeBold, TypeItalic, TypeCode, TypeQuote:
+			if e.URL != "" || e.Language != "" {
+				return fmt.Errorf("%s entity at %d has attributes it doesn't support", e.Type, e.Offset)
+			}
+		case TypePre:
+			if e.URL != "" || e.Language != "" && !languageRX.MatchString(e.Language) {
+				return fmt.Errorf("pre entity at %d has an invalid language", e.Offset)
+			}
+		case TypeLink:
+			if e.Language != "" || !ValidURL(e.URL) {
+				return fmt.Errorf("link entity at %d has an invalid URL", e.Offset)
+			}
+		default:
+			return fmt.Errorf("unknown entity type %q", e.Type)
+		}
+
+		if e.Offset < 0 || e.Length <= 0 || e.end() > length {
+			return fmt.Errorf("%s entity at %d is out of range", e.Type, e.Offset)
+		}
+		if !boundaries[e.Offset] || !boundaries[e.end()] {
+			return fmt.Errorf("%s entity at %d splits a character", e.Type, e.Offset)
+		}
+	}
+
+	// Walk the entities outermost first and keep the ones still open on a stack. Every entity
+	// has to fit inside the innermost open one.
+	sorted := Sorted(entities)
+	var open []Entity
+	for _, e := range sorted {
+		for len(open) > 0 && open[len(open)-1].end() <= e.Offset {
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			parent := open[len(open)-1]
+			if e.end() > parent.end() {
+				return fmt.Errorf("%s entity at %d partially overlaps %s entity at %d", e.Type, e.Offset,
+					parent.Type, parent.Offset)
+			}
+			if verbatim(parent.Type) {
+				return fmt.Errorf("%s entity at %d can't contain other entities", parent.Type, parent.Offset)
+			}
+		}
+		open = append(open, e)
+	}
+	return nil
+}
+
+// Sorted returns a copy of the entities ordered by offset, longer ones first, so that outer
+// entities come before the ones they contain. Of entities covering the same range, code and pre
+// come last, as they can only be innermost.
+func Sorted(entities []Entity) []Entity {
+	sorted := make([]Entity, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		if a.Length != b.Length {
+			return a.Length > b.Length
+		}
+		return !verbatim(a.Type) && verbatim(b.Type)
+	})
+	return sorted
+}
+
+// verbatim reports whether entities of the type show their text as is, without other formatting.
+func verbatim(entityType string) bool {
+	return entityType == TypeCode || entityType == TypePre
+}
+
+// Len returns the length of s in UTF-16 code units.
+func Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// utf16Len returns the number of UTF-16 code units needed for r. Runes outside the Basic
+// Multilingual Plane take two.
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,116 @@
+package richtext
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []Entity
+		valid    bool
+	}{
+		{"no entities", "hello", nil, true},
+		{"bold", "hello world", []Entity{{Type: TypeBold, Offset: 0, Length: 5}}, true},
+		{"whole text", "hello", []Entity{{Type: TypeItalic, Offset: 0, Length: 5}}, true},
+		{"nested", "hello world", []Entity{
+			{Type: TypeBold, Offset: 0, Length: 11},
+			{Type: TypeItalic, Offset: 6, Length: 5},
+		}, true},
+		{"same range", "hello", []Entity{
+			{Type: TypeCode, Offset: 0, Length: 5},
+			{Type: TypeBold, Offset: 0, Length: 5},
+		}, true},
+		{"adjacent", "abcd", []Entity{
+			{Type: TypeBold, Offset: 0, Length: 2},
+			{Type: TypeItalic, Offset: 2, Length: 2},
+		}, true},
+		{"astral characters", "😀 hi", []Entity{{Type: TypeBold, Offset: 0, Length: 2}}, true},
+		{"link", "docs", []Entity{{Type: TypeLink, Offset: 0, Length: 4, URL: "https://example.com/a?b=c"}}, true},
+		{"mailto link", "mail", []Entity{{Type: TypeLink, Offset: 0, Length: 4, URL: "mailto:a@example.com"}}, true},
+		{"pre with language", "x := 1", []Entity{{Type: TypePre, Offset: 0, Length: 6, Language: "go"}}, true},
+
+		{"unknown type", "hello", []Entity{{Type: "underline", Offset: 0, Length: 5}}, false},
+		{"empty", "hello", []Entity{{Type: TypeBold, Offset: 1, Length: 0}}, false},
+		{"negative offset", "hello", []Entity{{Type: TypeBold, Offset: -1, Length: 2}}, false},
+		{"past the end", "hello", []Entity{{Type: TypeBold, Offset: 3, Length: 3}}, false},
+		{"splits a surrogate pair", "😀", []Entity{{Type: TypeBold, Offset: 0, Length: 1}}, false},
+		{"partial overlap", "hello world", []Entity{
+			{Type: TypeBold, Offset: 0, Length: 7},
+			{Type: TypeItalic, Offset: 5, Length: 6},
+		}, false},
+		{"inside code", "hello", []Entity{
+			{Type: TypeCode, Offset: 0, Length: 5},
+			{Type: TypeBold, Offset: 1, Length: 2},
+		}, false},
+		{"javascript link", "x", []Entity{{Type: TypeLink, Offset: 0, Length: 1, URL: "javascript:alert(1)"}}, false},
+		{"relative link", "x", []Entity{{Type: TypeLink, Offset: 0, Length: 1, URL: "/path"}}, false},
+		{"bold with url", "x", []Entity{{Type: TypeBold, Offset: 0, Length: 1, URL: "https://example.com"}}, false},
+		{"pre with bad language", "x", []Entity{{Type: TypePre, Offset: 0, Length: 1, Language: `go"><script>`}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.text, tt.entities)
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() error = %v, want valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateTooManyEntities(t *testing.T) {
+	entities := make([]Entity, MaxEntities+1)
+	for i := range entities {
+		entities[i] = Entity{Type: TypeBold, Offset: i, Length: 1}
+	}
+	text := string(make([]byte, MaxEntities+1))
+
+	if err := Validate(text, entities); !errors.Is(err, ErrTooManyEntities) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrTooManyEntities)
+	}
+	if err := Validate(text, entities[:MaxEntities]); err != nil {
+		t.Errorf("Validate() with %d entities error = %v", MaxEntities, err)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []Entity
+		want     string
+	}{
+		{"plain", "hello", nil, "hello"},
+		{"escaped", `<script>alert("x")</script> & co`, nil,
+			"&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; co"},
+		{"line breaks", "a\nb", nil, "a<br>b"},
+		{"bold", "hello world", []Entity{{Type: TypeBold, Offset: 6, Length: 5}}, "hello <strong>world</strong>"},
+		{"nested", "hello world", []Entity{
+			{Type: TypeItalic, Offset: 6, Length: 5},
+			{Type: TypeBold, Offset: 0, Length: 11},
+		}, "<strong>hello <em>world</em></strong>"},
+		{"same range", "x", []Entity{
+			{Type: TypeCode, Offset: 0, Length: 1},
+			{Type: TypeBold, Offset: 0, Length: 1},
+		}, "<strong><code>x</code></strong>"},
+		{"link", "see docs", []Entity{{Type: TypeLink, Offset: 4, Length: 4, URL: `https://example.com/?a=1&b="2"`}},
+			`see <a href="https://example.com/?a=1&amp;b=&#34;2&#34;" rel="nofollow noopener noreferrer">docs</a>`},
+		{"pre keeps line breaks", "a\nb", []Entity{{Type: TypePre, Offset: 0, Length: 3, Language: "go"}},
+			"<pre>a\nb</pre>"},
+		{"quote", "a\nb", []Entity{{Type: TypeQuote, Offset: 0, Length: 3}}, "<blockquote>a<br>b</blockquote>"},
+		{"astral characters", "😀 <b>", []Entity{{Type: TypeItalic, Offset: 3, Length: 3}}, "😀 <em>&lt;b&gt;</em>"},
+		{"invalid entities are dropped", "<hi>", []Entity{{Type: TypeLink, Offset: 0, Length: 4, URL: "javascript:alert(1)"}},
+			"&lt;hi&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderHTML(tt.text, tt.entities); got != tt.want {
+				t.Errorf("RenderHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}